@@ -0,0 +1,275 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// backend performs the git operations holo-git-repos needs. Network
+// operations take a context, so that they can be timed out and
+// cancelled (see retryNetwork). Errors are reported as *GitError, so
+// that they can be classified (see Classify).
+type backend interface {
+	// Clone clones the repository at url into the empty directory path.
+	Clone(ctx context.Context, url string, path string) error
+	// Fetch fetches the branches and tags of origin into the repository
+	// denoted by path. Remote-tracking branches that were deleted on the
+	// remote are removed.
+	Fetch(ctx context.Context, path string) error
+	// ListRemote returns the refs of the remote at url, including peeled
+	// tags named like 'refs/tags/v1^{}'. Only the refs in names need to be
+	// returned. If path is not emptystring, it denotes the repository to
+	// run in.
+	ListRemote(ctx context.Context, path string, url string, names []string) ([]gitRef, error)
+	// UpdateRemoteHead asks origin which its default branch is and
+	// records it as refs/remotes/origin/HEAD.
+	UpdateRemoteHead(ctx context.Context, path string) error
+
+	// Checkout checks out revision, i.e. a local branch, tag or commit ID,
+	// keeping changes in the worktree.
+	Checkout(path string, revision string) error
+	// CheckoutBranch checks out the local branch, creating it from the
+	// remote-tracking branch of origin with the same name if necessary.
+	CheckoutBranch(path string, branch string) error
+	// FastForward fast-forwards the checked out branch to revision.
+	FastForward(path string, revision string) error
+	// ForceCheckout resets branch to commit and checks it out, or checks
+	// out commit detached if branch is emptystring. Changes in the
+	// worktree are discarded.
+	ForceCheckout(path string, branch string, commit string) error
+	// Status returns the changed and untracked files in the worktree,
+	// sorted by path. Untracked directories are listed file by file.
+	Status(path string) ([]statusEntry, error)
+	// Diff writes the changes between commit and the worktree, including
+	// untracked files, to w as a unified diff.
+	Diff(path string, commit string, w io.Writer) error
+
+	// Resolve returns the ID of the commit revision points to.
+	Resolve(path string, revision string) (string, error)
+	// SymbolicRef returns the short name of the ref the symbolic ref
+	// name points to, e.g. 'main' for HEAD or 'origin/main' for
+	// refs/remotes/origin/HEAD.
+	SymbolicRef(path string, name string) (string, error)
+	// RemoteURLs returns the URLs of all remotes by name, as configured.
+	RemoteURLs(path string) (map[string]string, error)
+	// SetRemoteURL sets the URL of the remote name, adding the remote if
+	// it does not exist.
+	SetRemoteURL(path string, name string, url string) error
+	// Log returns the commits that commit has but HEAD has not, newest
+	// first, one line of format '<abbreviated ID> <subject>' each.
+	Log(path string, commit string) ([]string, error)
+	// AheadBehind counts the commits HEAD is ahead of and behind commit.
+	AheadBehind(path string, commit string) (ahead int, behind int, err error)
+
+	// ListRefs returns the refs below prefix.
+	ListRefs(path string, prefix string) ([]gitRef, error)
+	// UpdateRef sets the ref name to commit, creating it if necessary.
+	UpdateRef(path string, name string, commit string) error
+	// DeleteRef deletes the ref name.
+	DeleteRef(path string, name string) error
+	// Stash saves the uncommitted changes and untracked files like
+	// 'git stash push --include-untracked' does and returns the stash
+	// commit, or emptystring if the worktree is clean. Unlike git stash,
+	// it does not add the commit to the stash list.
+	Stash(path string, message string) (string, error)
+	// ApplyStash restores the changes saved in the stash commit like
+	// 'git stash apply --index' does, into the clean worktree.
+	ApplyStash(path string, commit string) error
+}
+
+// backends are the backends by the name used in HOLO_GIT_REPOS_BACKEND.
+var backends = map[string]backend{
+	"git":    execBackend{},
+	"go-git": goGitBackend{},
+}
+
+// currentBackend is the backend all git operations go through.
+var currentBackend backend = execBackend{}
+
+// SetupBackend selects the backend named by HOLO_GIT_REPOS_BACKEND,
+// "git" by default.
+func SetupBackend() error {
+	name := os.Getenv("HOLO_GIT_REPOS_BACKEND")
+	if name == "" {
+		name = "git"
+	}
+	b, ok := backends[name]
+	if !ok {
+		return fmt.Errorf("invalid HOLO_GIT_REPOS_BACKEND '%s', expected git or go-git", name)
+	}
+	currentBackend = b
+	return nil
+}
+
+// execBackend runs the git command (see runner).
+type execBackend struct{}
+
+func (execBackend) Clone(ctx context.Context, url string, path string) error {
+	return execGit(ctx, "", nil, "clone", url, path)
+}
+
+func (execBackend) Fetch(ctx context.Context, path string) error {
+	return execGit(ctx, path, nil, "fetch", "--quiet", "--prune", "--tags", "origin")
+}
+
+func (execBackend) ListRemote(ctx context.Context, path string, url string, names []string) ([]gitRef, error) {
+	var stdout strings.Builder
+	if err := execGit(ctx, path, &stdout, append([]string{"ls-remote", url}, names...)...); err != nil {
+		return nil, err
+	}
+	return parseRefs(stdout.String())
+}
+
+func (execBackend) UpdateRemoteHead(ctx context.Context, path string) error {
+	return execGit(ctx, path, nil, "remote", "set-head", "origin", "--auto")
+}
+
+func (execBackend) Checkout(path string, revision string) error {
+	return runGitInDir(false, path, "checkout", revision)
+}
+
+func (b execBackend) CheckoutBranch(path string, branch string) error {
+	if _, err := b.Resolve(path, "refs/heads/"+branch); err != nil {
+		return runGitInDir(false, path, "checkout", "--quiet", "-b", branch, "--track", "origin/"+branch)
+	}
+	return runGitInDir(false, path, "checkout", "--quiet", branch)
+}
+
+func (execBackend) FastForward(path string, revision string) error {
+	return runGitInDir(false, path, "merge", "--quiet", "--ff-only", revision)
+}
+
+func (execBackend) ForceCheckout(path string, branch string, commit string) error {
+	if branch == "" {
+		return runGitInDir(false, path, "checkout", "--quiet", "--force", "--detach", commit)
+	}
+	return runGitInDir(false, path, "checkout", "--quiet", "--force", "-B", branch, commit)
+}
+
+func (execBackend) Status(path string) ([]statusEntry, error) {
+	entries, err := gitStatus(path)
+	// git lists the untracked files last
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, err
+}
+
+func (b execBackend) Diff(path string, commit string, w io.Writer) error {
+	if err := execGit(gitContext, path, w, "diff", "--no-color", commit); err != nil {
+		return err
+	}
+	entries, err := b.Status(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Untracked() {
+			continue
+		}
+		err := execGit(gitContext, path, w, "diff", "--no-color", "--no-index", "--", "/dev/null", entry.Path)
+		// with --no-index, git diff exits with 1 if there are differences
+		var gitErr *GitError
+		if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (execBackend) Resolve(path string, revision string) (string, error) {
+	return gitOutput(path, "rev-parse", "--verify", "--quiet", revision+"^{commit}")
+}
+
+func (execBackend) SymbolicRef(path string, name string) (string, error) {
+	return gitOutput(path, "symbolic-ref", "--quiet", "--short", name)
+}
+
+func (execBackend) RemoteURLs(path string) (map[string]string, error) {
+	return remoteURLs(path)
+}
+
+func (b execBackend) SetRemoteURL(path string, name string, url string) error {
+	urls, err := b.RemoteURLs(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := urls[name]; !ok {
+		return runGitInDir(false, path, "remote", "add", name, url)
+	}
+	return runGitInDir(false, path, "remote", "set-url", name, url)
+}
+
+func (execBackend) Log(path string, commit string) ([]string, error) {
+	out, err := gitOutput(path, "log", "--oneline", "HEAD.."+commit)
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+func (execBackend) AheadBehind(path string, commit string) (int, int, error) {
+	out, err := gitOutput(path, "rev-list", "--left-right", "--count", commit+"...HEAD")
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseAheadBehind(out)
+}
+
+func (execBackend) ListRefs(path string, prefix string) ([]gitRef, error) {
+	return listRefs(path, prefix)
+}
+
+func (execBackend) UpdateRef(path string, name string, commit string) error {
+	return runGitInDir(false, path, "update-ref", name, commit)
+}
+
+func (execBackend) DeleteRef(path string, name string) error {
+	return runGitInDir(false, path, "update-ref", "-d", name)
+}
+
+func (b execBackend) Stash(path string, message string) (string, error) {
+	entries, err := b.Status(path)
+	if err != nil || len(entries) == 0 {
+		return "", err
+	}
+	args := append(backupIdentity, "stash", "push", "--quiet", "--include-untracked", "-m", message)
+	if err := runGitInDir(false, path, args...); err != nil {
+		return "", err
+	}
+	commit, err := gitOutput(path, "rev-parse", "refs/stash")
+	if err != nil {
+		return "", err
+	}
+	// the caller keeps the commit, so it need not clutter the stash list
+	return commit, runGitInDir(false, path, "stash", "drop", "--quiet")
+}
+
+func (execBackend) ApplyStash(path string, commit string) error {
+	return runGitInDir(false, path, "stash", "apply", "--quiet", "--index", commit)
+}
@@ -0,0 +1,176 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupRefPrefix is the prefix of the refs that backups are stored in.
+// A backup consists of the refs
+//   - refs/holo-backup/<timestamp>/HEAD: the commit HEAD was at,
+//   - refs/holo-backup/<timestamp>/branch/<name>: the branch HEAD was on, if any,
+//   - refs/holo-backup/<timestamp>/worktree: a stash commit with the
+//     uncommitted changes and untracked files, if there were any.
+const backupRefPrefix = "refs/holo-backup/"
+
+// backupIdentity is used for the stash commits of backups, so that they
+// can be created even if the user has no identity configured.
+var backupIdentity = []string{"-c", "user.name=" + backupName, "-c", "user.email=" + backupEmail}
+
+const (
+	backupName  = "holo-git-repos"
+	backupEmail = "holo-git-repos@localhost"
+)
+
+// backup snapshots HEAD and the worktree of the git repository denoted
+// by path into backup refs and returns the timestamp identifying the
+// backup. Uncommitted changes and untracked files are moved out of the
+// worktree into the backup, so that the worktree is clean afterwards.
+func backup(path string) (string, error) {
+	// with nanoseconds, backups made in quick succession do not overwrite
+	// each other
+	timestamp := time.Now().UTC().Format("20060102T150405.000000000Z")
+	prefix := backupRefPrefix + timestamp + "/"
+
+	head, err := currentBackend.Resolve(path, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if err := currentBackend.UpdateRef(path, prefix+"HEAD", head); err != nil {
+		return "", err
+	}
+	if branch, err := currentBackend.SymbolicRef(path, "HEAD"); err == nil {
+		if err := currentBackend.UpdateRef(path, prefix+"branch/"+branch, head); err != nil {
+			return "", err
+		}
+	}
+
+	stash, err := currentBackend.Stash(path, "holo-git-repos backup "+timestamp)
+	if err != nil || stash == "" {
+		return timestamp, err
+	}
+	if err := currentBackend.UpdateRef(path, prefix+"worktree", stash); err != nil {
+		return "", err
+	}
+	return timestamp, nil
+}
+
+// backupRefs returns the refs of all backups in the git repository
+// denoted by path, grouped by timestamp.
+func backupRefs(path string) (map[string][]string, error) {
+	refs, err := currentBackend.ListRefs(path, backupRefPrefix)
+	if err != nil {
+		return nil, err
+	}
+	backups := make(map[string][]string)
+	for _, ref := range refs {
+		timestamp := strings.SplitN(strings.TrimPrefix(ref.Name, backupRefPrefix), "/", 2)[0]
+		backups[timestamp] = append(backups[timestamp], ref.Name)
+	}
+	return backups, nil
+}
+
+// restoreBackup restores HEAD and the worktree of the git repository
+// denoted by path from the latest backup and then removes that backup,
+// so that restoring again goes back one more backup. It returns the
+// timestamp of the restored backup.
+func restoreBackup(path string) (string, error) {
+	backups, err := backupRefs(path)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", errors.New("no backup found in " + path)
+	}
+	timestamps := make([]string, 0, len(backups))
+	for timestamp := range backups {
+		timestamps = append(timestamps, timestamp)
+	}
+	sort.Strings(timestamps)
+	timestamp := timestamps[len(timestamps)-1]
+	prefix := backupRefPrefix + timestamp + "/"
+
+	// restoring changes into a dirty worktree could mix them up
+	clean, err := isClean(path)
+	if err != nil {
+		return "", err
+	}
+	if !clean {
+		return "", errors.New(path + " has uncommitted changes, commit or stash them first")
+	}
+
+	// restore HEAD, including the branch it was on
+	head, err := currentBackend.Resolve(path, prefix+"HEAD")
+	if err != nil {
+		return "", err
+	}
+	branch := ""
+	for _, ref := range backups[timestamp] {
+		if strings.HasPrefix(ref, prefix+"branch/") {
+			branch = strings.TrimPrefix(ref, prefix+"branch/")
+		}
+	}
+	if err := currentBackend.ForceCheckout(path, branch, head); err != nil {
+		return "", err
+	}
+
+	// restore uncommitted changes and untracked files
+	return timestamp, restoreBackupWorktree(path, timestamp, backups[timestamp])
+}
+
+// restoreBackupWorktree applies the uncommitted changes and untracked
+// files saved in the backup with the given timestamp and refs to the
+// clean worktree of the git repository denoted by path. Then the backup
+// is deleted.
+func restoreBackupWorktree(path string, timestamp string, refs []string) error {
+	prefix := backupRefPrefix + timestamp + "/"
+	if stash, err := currentBackend.Resolve(path, prefix+"worktree"); err == nil {
+		if err := currentBackend.ApplyStash(path, stash); err != nil {
+			return err
+		}
+	}
+	for _, ref := range refs {
+		if err := currentBackend.DeleteRef(path, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Undo restores the repository of the entity with ID entityId to the
+// state before the last force-apply (see backup). It returns the target
+// path and the timestamp of the restored backup.
+func Undo(entityId string, opts Options) (path string, timestamp string, err error) {
+	e, err := ParseEntity(entityId, opts)
+	if err != nil {
+		return "", "", err
+	}
+	lock, err := lockTarget(e.Path)
+	if err != nil {
+		return "", "", err
+	}
+	defer lock.unlock()
+	timestamp, err = restoreBackup(e.Path)
+	return e.Path, timestamp, err
+}
@@ -0,0 +1,155 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestBackupUndo(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	first := gitHead(t, target)
+
+	// local changes and a new upstream commit
+	tracked, err := gitOutput(target, "ls-files")
+	assertErrNil(t, err, "Cannot list tracked files")
+	trackedFile := path.Join(target, strings.Fields(tracked)[0])
+	assertErrNil(t, ioutil.WriteFile(trackedFile, []byte("modified\n"), 0644), "Cannot modify file")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "untracked"), []byte("new\n"), 0644), "Cannot write file")
+	second := makeTemporaryCommit(t, source)
+
+	// force-apply saves the changes and updates
+	assertErrNil(t, Apply(entityId, Options{Force: true}), "Force-apply failed")
+	assertEq(t, gitHead(t, target), second)
+	clean, err := isClean(target)
+	assertErrNil(t, err, "Cannot check worktree")
+	assertEq(t, clean, true)
+	backups, err := backupRefs(target)
+	assertErrNil(t, err, "Cannot list backups")
+	assertEq(t, len(backups), 1)
+
+	// undo restores HEAD, branch and changes
+	undone, timestamp, err := Undo(entityId, Options{})
+	assertErrNil(t, err, "Undo failed")
+	assertEq(t, undone, target)
+	_, ok := backups[timestamp]
+	assertEq(t, ok, true)
+	assertEq(t, gitHead(t, target), first)
+	branch, err := gitOutput(target, "symbolic-ref", "--short", "HEAD")
+	assertErrNil(t, err, "HEAD is not on a branch")
+	assertEq(t, branch, "main")
+	content, err := ioutil.ReadFile(trackedFile)
+	assertErrNil(t, err, "Cannot read modified file")
+	assertEq(t, string(content), "modified\n")
+	content, err = ioutil.ReadFile(path.Join(target, "untracked"))
+	assertErrNil(t, err, "Cannot read untracked file")
+	assertEq(t, string(content), "new\n")
+
+	// the backup is used up
+	_, _, err = Undo(entityId, Options{})
+	assertEq(t, err != nil, true)
+}
+
+func TestBackupDetached(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	first := gitHead(t, source)
+	makeTemporaryCommit(t, source)
+	target := cloneTemporary(t, source)
+	assertErrNil(t, runGitInDir(false, target, "checkout", "--quiet", "--detach", first), "Cannot detach HEAD")
+
+	_, err := backup(target)
+	assertErrNil(t, err, "Backup failed")
+	assertErrNil(t, runGitInDir(false, target, "checkout", "--quiet", "main"), "Cannot check out main")
+	_, err = restoreBackup(target)
+	assertErrNil(t, err, "Restore failed")
+	assertEq(t, gitHead(t, target), first)
+	_, err = gitOutput(target, "symbolic-ref", "--quiet", "HEAD")
+	assertEq(t, err != nil, true) // detached
+}
+
+// backups made right after each other are kept apart
+func TestBackupTwice(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	for _, content := range []string{"first\n", "second\n"} {
+		assertErrNil(t, ioutil.WriteFile(path.Join(target, "untracked"), []byte(content), 0644), "Cannot write file")
+		_, err := backup(target)
+		assertErrNil(t, err, "Backup failed")
+	}
+	backups, err := backupRefs(target)
+	assertErrNil(t, err, "Cannot list backups")
+	assertEq(t, len(backups), 2)
+
+	// the latest backup is restored first
+	_, err = restoreBackup(target)
+	assertErrNil(t, err, "Cannot restore backup")
+	content, err := ioutil.ReadFile(path.Join(target, "untracked"))
+	assertErrNil(t, err, "Cannot read restored file")
+	assertEq(t, string(content), "second\n")
+}
+
+// failingUpdate is a backend that cannot fast-forward.
+type failingUpdate struct {
+	backend
+}
+
+func (failingUpdate) FastForward(path string, revision string) error {
+	return errors.New("cannot fast-forward")
+}
+
+// when the update fails and the repository is recloned, the changes go
+// into quarantine with it, so restore is suggested instead of undo
+func TestBackupRecloned(t *testing.T) {
+	stateDir := setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "untracked"), []byte("new\n"), 0644), "Cannot write file")
+	newCommit := makeTemporaryCommit(t, source)
+	defer useBackend(failingUpdate{execBackend{}})()
+
+	var err error
+	warnings := getFunctionOutput(func() {
+		saved := os.Stderr
+		os.Stderr = os.Stdout
+		err = Apply(entityId, Options{Force: true})
+		os.Stderr = saved
+	})
+	assertErrNil(t, err, "Force-apply failed")
+	assertEq(t, gitHead(t, target), newCommit)
+	assertEq(t, strings.Contains(warnings, "holo-git-repos undo"), false)
+	assertEq(t, strings.Contains(warnings, "holo-git-repos restore"), true)
+	entries, err := Quarantined()
+	assertErrNil(t, err, "Cannot list quarantine")
+	assertEq(t, len(entries), 1)
+	content, err := ioutil.ReadFile(path.Join(stateDir, "quarantine", entries[0].ID, "untracked"))
+	assertErrNil(t, err, "Cannot read quarantined file")
+	assertEq(t, string(content), "new\n")
+}
@@ -0,0 +1,59 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package gitrepos provisions git repositories described by entity
+// files, as the holo plugin holo-git-repos does.
+//
+// An entity file has one 'key=value' per line, e.g.
+//
+//	url=https://github.com/some-user/some-repo
+//	path=/home/user/some-repository
+//	revision=master
+//
+// ParseEntityFile parses a single file, ParseEntity and ParseEntities
+// parse the files of a resource directory, Validate reports every problem
+// of an entity file. The holo operations are Scan, Apply and Diff. Plan
+// shows what Apply would do, Status inspects the repositories, Undo
+// reverts a force-apply, and Quarantined and Restore give access to the
+// targets that force-applying moved out of the way. Failures are
+// classified by Classify and explained by FailureHint.
+//
+// Only the resource directory and the behavior of the operations are
+// configured with Options. Everything else is read from the same
+// environment variables as the holo-git-repos command reads, and applies
+// to the whole process:
+//
+//   - HOLO_STATE_DIR: where the state of the last applies, the locks and
+//     the quarantine are kept (the quarantine falls back to
+//     HOLO_CACHE_DIR),
+//   - HOLO_GIT_REPOS_BACKEND: whether the git command or go-git is used
+//     (see SetupBackend),
+//   - HOLO_GIT_REPOS_NETWORK_TIMEOUT, HOLO_GIT_REPOS_NETWORK_RETRIES and
+//     HOLO_GIT_REPOS_OFFLINE: the network policy, which entity files can
+//     override,
+//   - HOLO_GIT_REPOS_LOCK_TIMEOUT: how long to wait for a locked target,
+//   - HOLO_GIT_REPOS_ALLOWED_ROOTS: below which directories targets may
+//     be deleted,
+//   - HOLO_GIT_REPOS_LOG and HOLO_GIT_REPOS_LOG_FILE: logging (see
+//     SetupLogging).
+//
+// Programs that need different settings for different calls have to
+// change the environment in between, and must not do so while an
+// operation is running.
+package gitrepos
@@ -0,0 +1,334 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Entity represents a git repository entity for holo, as read from an
+// entity file. The optional settings are emptystring if they are not
+// set in the file.
+type Entity struct {
+	ID       string // name of the entity file
+	File     string // path of the entity file
+	URL      string
+	Path     string
+	Revision string
+	Timeout  string // overrides the global network timeout if not empty (see networkPolicy)
+	Retries  string // overrides the global network retries if not empty
+	Offline  string // overrides the global offline mode if not empty
+}
+
+// entityKey describes a key that may appear in an entity file.
+// Keys that are not required are set to their default value if they
+// are missing from the file. If check is not nil, it validates non-empty
+// values.
+type entityKey struct {
+	name       string
+	required   bool
+	defaultVal string
+	set        func(e *Entity, value string)
+	check      func(value string) error
+}
+
+// entityKeys lists all keys that are understood in entity files.
+var entityKeys = []entityKey{
+	{name: "url", required: true, set: func(e *Entity, v string) { e.URL = v }},
+	{name: "path", required: true, set: func(e *Entity, v string) { e.Path = v }},
+	{name: "revision", defaultVal: "", set: func(e *Entity, v string) { e.Revision = v }},
+	{name: "timeout", defaultVal: "", set: func(e *Entity, v string) { e.Timeout = v },
+		check: func(v string) error { _, err := parseTimeout(v); return err }},
+	{name: "retries", defaultVal: "", set: func(e *Entity, v string) { e.Retries = v },
+		check: func(v string) error { _, err := parseRetries(v); return err }},
+	{name: "offline", defaultVal: "", set: func(e *Entity, v string) { e.Offline = v },
+		check: func(v string) error { _, err := parseOffline(v); return err }},
+}
+
+// lookupEntityKey returns the entityKey with the given name.
+func lookupEntityKey(name string) (entityKey, bool) {
+	for _, k := range entityKeys {
+		if k.name == name {
+			return k, true
+		}
+	}
+	return entityKey{}, false
+}
+
+// utf8BOM is the byte order mark some editors put at the start of a file.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// Diagnostic is a problem found in an entity file. Warnings do not
+// prevent the entity from being used, errors do.
+type Diagnostic struct {
+	File    string
+	Line    int // 0 if the problem does not belong to a single line
+	Warning bool
+	Msg     string
+}
+
+// String formats the diagnostic as 'file:line: message'.
+func (d Diagnostic) String() string {
+	pos := d.File
+	if d.Line > 0 {
+		pos += ":" + strconv.Itoa(d.Line)
+	}
+	if d.Warning {
+		return pos + ": warning: " + d.Msg
+	}
+	return pos + ": " + d.Msg
+}
+
+// hasErrors reports whether any of the diagnostics is an error.
+func hasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if !d.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDiagnostics prints warnings to stderr and returns a *ParseError
+// for the file if there are errors.
+func checkDiagnostics(file string, diags []Diagnostic) error {
+	if hasErrors(diags) {
+		return &ParseError{file, diags}
+	}
+	for _, d := range diags {
+		Warn(d.String())
+	}
+	return nil
+}
+
+// parseEntityLine parses a line of format 'key=value'.
+// Only the first '=' separates key and value, so the value may contain
+// further '=' characters (e.g. in URL query strings).
+func parseEntityLine(line []byte) (key string, value string, err error) {
+	lineSplit := strings.SplitN(string(line), "=", 2)
+
+	if len(lineSplit) != 2 {
+		return "", "", errors.New("wrong line format, expected 'key=value': '" + string(line) + "'")
+	}
+
+	key = strings.TrimSpace(lineSplit[0])
+	value = strings.TrimSpace(lineSplit[1])
+	if key == "" {
+		return "", "", errors.New("missing key in line: '" + string(line) + "'")
+	}
+	return
+}
+
+// ParseEntityFile parses a file into an entity instance. The name is
+// only used in diagnostics.
+// Keys may appear in any order. Blank lines and lines starting with '#'
+// are ignored, as are a leading byte order mark and CRLF line endings.
+// Unknown keys are skipped with a warning, so that entity files written
+// for newer versions still work. All problems in the file are returned,
+// not only the first one.
+func ParseEntityFile(name string, file io.Reader) (Entity, []Diagnostic) {
+	var e Entity
+	var diags []Diagnostic
+	seen := make(map[string]int) // key -> line number
+
+	problem := func(line int, warning bool, format string, a ...interface{}) {
+		diags = append(diags, Diagnostic{name, line, warning, fmt.Sprintf(format, a...)})
+	}
+
+	scanner := bufio.NewScanner(file)
+	lineNo := 1
+	for ; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if lineNo == 1 {
+			line = bytes.TrimPrefix(line, utf8BOM)
+		}
+		line = bytes.TrimSpace(line) // also removes the '\r' of CRLF
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		k, v, err := parseEntityLine(line)
+		if err != nil {
+			problem(lineNo, false, "%s", err)
+			continue
+		}
+		key, known := lookupEntityKey(k)
+		if !known {
+			problem(lineNo, true, "unknown key '%s' is ignored", k)
+			continue
+		}
+		if first, dup := seen[k]; dup {
+			problem(lineNo, false, "duplicate key '%s' (first set in line %d)", k, first)
+			continue
+		}
+		seen[k] = lineNo
+		if key.required && v == "" {
+			problem(lineNo, false, "empty value for required key '%s'", k)
+		}
+		if key.check != nil && v != "" {
+			if err := key.check(v); err != nil {
+				problem(lineNo, false, "invalid value for key '%s': %s", k, err)
+			}
+		}
+		if k == "path" && v != "" && !filepath.IsAbs(v) {
+			problem(lineNo, false, "path '%s' is not absolute", v)
+		}
+		key.set(&e, v)
+	}
+	if err := scanner.Err(); err != nil {
+		problem(lineNo, false, "cannot read file: %s", err)
+	}
+
+	// fill in defaults and check for missing keys
+	for _, key := range entityKeys {
+		if _, ok := seen[key.name]; ok {
+			continue
+		}
+		if key.required {
+			problem(0, false, "missing key '%s'", key.name)
+			continue
+		}
+		key.set(&e, key.defaultVal)
+	}
+	return e, diags
+}
+
+// resourceDir returns the holo resource directory, i.e.
+// opts.ResourceDir or, if that is not set, $HOLO_RESOURCE_DIR.
+func resourceDir(opts Options) (string, error) {
+	resDirName := opts.ResourceDir
+	if resDirName == "" {
+		resDirName = os.Getenv("HOLO_RESOURCE_DIR")
+	}
+	if resDirName == "" {
+		return "", errors.New("HOLO_RESOURCE_DIR empty")
+	}
+	return resDirName, nil
+}
+
+// EntityIDs returns the IDs of all entities in the holo resource
+// directory, i.e. the names of the files in it.
+func EntityIDs(opts Options) ([]string, error) {
+	resDirName, err := resourceDir(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// open directory
+	resDir, err := os.Open(resDirName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open HOLO_RESOURCE_DIR: %w", err)
+	}
+	defer resDir.Close()
+
+	// read file names
+	names, err := resDir.Readdirnames(0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read files from HOLO_RESOURCE_DIR: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadEntity opens and parses the entity with id ID. Problems with the
+// entity file, including a file that cannot be opened, are returned as
+// diagnostics. The error is only set if the resource directory is not
+// configured.
+func loadEntity(id string, opts Options) (Entity, []Diagnostic, error) {
+	resDirName, err := resourceDir(opts)
+	if err != nil {
+		return Entity{}, nil, err
+	}
+	filePath := filepath.Join(resDirName, id)
+	entityFile, err := os.Open(filePath)
+	if err != nil {
+		diags := []Diagnostic{{File: filePath, Msg: "cannot open entity file: " + err.Error()}}
+		return Entity{ID: id, File: filePath}, diags, nil
+	}
+	defer entityFile.Close()
+
+	e, diags := ParseEntityFile(filePath, entityFile)
+	e.ID = id
+	e.File = filePath
+	return e, diags, nil
+}
+
+// ParseEntity parses the entity with id ID in the resource directory (see
+// Options). Warnings are printed to stderr, errors in the entity file are
+// returned as *ParseError.
+func ParseEntity(id string, opts Options) (Entity, error) {
+	e, diags, err := loadEntity(id, opts)
+	if err != nil {
+		return Entity{}, err
+	}
+	if err := checkDiagnostics(e.File, diags); err != nil {
+		return Entity{}, err
+	}
+	return e, nil
+}
+
+// ParseEntities parses all entities in the resource directory (see
+// Options).
+// A broken entity file does not stop the others from being parsed: all
+// entities that could be parsed are returned, together with a
+// *BrokenEntitiesError listing the broken ones.
+func ParseEntities(opts Options) ([]Entity, error) {
+	ids, err := EntityIDs(opts)
+	if err != nil {
+		return nil, err
+	}
+	entities := make([]Entity, 0, len(ids))
+	var broken []error
+	for _, id := range ids {
+		e, err := ParseEntity(id, opts)
+		if err != nil {
+			broken = append(broken, err)
+			continue
+		}
+		entities = append(entities, e)
+	}
+	if len(broken) > 0 {
+		return entities, &BrokenEntitiesError{broken}
+	}
+	return entities, nil
+}
+
+// ReportBrokenEntities prints a warning for every broken entity if err
+// is a *BrokenEntitiesError. Any other error is returned, since it means
+// that no entities could be parsed at all.
+func ReportBrokenEntities(err error) error {
+	var broken *BrokenEntitiesError
+	if !errors.As(err, &broken) {
+		return err
+	}
+	for _, e := range broken.Errors {
+		Warn("skipping broken entity:", e)
+	}
+	return nil
+}
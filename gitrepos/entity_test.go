@@ -0,0 +1,159 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestEntityParseLine(t *testing.T) {
+	k, v, err := parseEntityLine([]byte("key=value"))
+	assertErrNil(t, err, "Cannot parse line")
+	assertEq(t, k, "key")
+	assertEq(t, v, "value")
+}
+
+func TestEntityParseLineEqualsInValue(t *testing.T) {
+	k, v, err := parseEntityLine([]byte(" url = https://example.com/repo?a=b&c=d "))
+	assertErrNil(t, err, "Cannot parse line")
+	assertEq(t, k, "url")
+	assertEq(t, v, "https://example.com/repo?a=b&c=d")
+}
+
+func TestEntityParseLineMalformed(t *testing.T) {
+	for _, line := range []string{"no equals sign", "=value"} {
+		_, _, err := parseEntityLine([]byte(line))
+		if err == nil {
+			t.Fatalf("Expected error for line '%s'", line)
+		}
+	}
+}
+
+func TestEntityParseFile(t *testing.T) {
+
+	// create temporary entity file
+	testUrl := "TestEntityParseFile_testUrl"
+	testPath := "/TestEntityParseFile_testPath"
+	testRevision := "TestEntityParseFile_testRevision"
+	filePath := makeTemporaryEntityFile(t, os.TempDir(), testUrl, testPath, testRevision)
+
+	// call function
+	file, err := os.Open(filePath)
+	assertErrNil(t, err, "Cannot re-open temporary file")
+	e, diags := ParseEntityFile(filePath, file)
+	assertEq(t, hasErrors(diags), false)
+	assertEq(t, e.URL, testUrl)
+	assertEq(t, e.Path, testPath)
+	assertEq(t, e.Revision, testRevision)
+}
+
+func TestEntityParseFileFlexible(t *testing.T) {
+	content := "\xef\xbb\xbf# a comment\r\n" +
+		"\r\n" +
+		"path = /some/path\r\n" +
+		"  # indented comment\r\n" +
+		"unknown=key\r\n" +
+		"url=https://example.com/repo?a=b\r\n"
+	e, diags := ParseEntityFile("test", strings.NewReader(content))
+	assertEq(t, e.URL, "https://example.com/repo?a=b")
+	assertEq(t, e.Path, "/some/path")
+	assertEq(t, e.Revision, "") // default
+	assertEq(t, len(diags), 1)
+	assertEq(t, diags[0].String(), "test:5: warning: unknown key 'unknown' is ignored")
+}
+
+func TestEntityParseFileDiagnostics(t *testing.T) {
+	content := "url=a\n" +
+		"garbage\n" +
+		"url=b\n" +
+		"path=relative\n"
+	_, diags := ParseEntityFile("test", strings.NewReader(content))
+	expected := []string{
+		"test:2: wrong line format, expected 'key=value': 'garbage'",
+		"test:3: duplicate key 'url' (first set in line 1)",
+		"test:4: path 'relative' is not absolute",
+	}
+	assertEq(t, len(diags), len(expected))
+	for i := range expected {
+		assertEq(t, diags[i].String(), expected[i])
+	}
+
+	_, diags = ParseEntityFile("test", strings.NewReader("revision=main\n"))
+	assertEq(t, len(diags), 2)
+	assertEq(t, diags[0].String(), "test: missing key 'url'")
+	assertEq(t, diags[1].String(), "test: missing key 'path'")
+}
+
+func TestEntityParse(t *testing.T) {
+
+	// create temporary entity file
+	testUrl := "TestEntityParse_testUrl"
+	testPath := "/TestEntityParse_testPath"
+	testRevision := "TestEntityParse_testRevision"
+	filePath := makeTemporaryEntityFile(t, os.TempDir(), testUrl, testPath, testRevision)
+	entityId := path.Base(filePath)
+
+	// call function
+	os.Setenv("HOLO_RESOURCE_DIR", path.Dir(filePath))
+	e, err := ParseEntity(entityId, Options{})
+	assertErrNil(t, err, "Cannot parse entity")
+	assertEq(t, e.URL, testUrl)
+	assertEq(t, e.Path, testPath)
+	assertEq(t, e.Revision, testRevision)
+}
+
+func TestEntities(t *testing.T) {
+
+	// create temporary directory with entity file
+	tempDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	testUrl := "TestEntities_testUrl"
+	testPath := "/TestEntities_testPath"
+	testRevision := "TestEntities_testRevision"
+	_ = makeTemporaryEntityFile(t, tempDir, testUrl, testPath, testRevision)
+
+	// call function
+	os.Setenv("HOLO_RESOURCE_DIR", tempDir)
+	entities, err := ParseEntities(Options{})
+	assertErrNil(t, err, "Cannot parse entities")
+	assertEq(t, len(entities), 1)
+	assertEq(t, entities[0].URL, testUrl)
+	assertEq(t, entities[0].Path, testPath)
+	assertEq(t, entities[0].Revision, testRevision)
+}
+
+// Options.ResourceDir takes precedence over HOLO_RESOURCE_DIR
+func TestEntitiesResourceDirOption(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := makeTemporaryEntityFile(t, tempDir, "TestEntitiesResourceDirOption_testUrl", "/path", "")
+	os.Setenv("HOLO_RESOURCE_DIR", t.TempDir())
+	defer os.Unsetenv("HOLO_RESOURCE_DIR")
+
+	entities, err := ParseEntities(Options{ResourceDir: tempDir})
+	assertErrNil(t, err, "Cannot parse entities")
+	assertEq(t, len(entities), 1)
+	assertEq(t, entities[0].ID, path.Base(filePath))
+	assertEq(t, entities[0].File, filePath)
+	assertEq(t, entities[0].URL, "TestEntitiesResourceDirOption_testUrl")
+}
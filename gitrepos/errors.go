@@ -0,0 +1,150 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotChanged is returned by Apply if the entity already was in the
+// desired state, so there was nothing to do.
+var ErrNotChanged = errors.New("not changed")
+
+// ParseError is returned when an entity file contains errors. It holds
+// all problems found in the file, including warnings.
+type ParseError struct {
+	File        string
+	Diagnostics []Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	var msgs []string
+	for _, d := range e.Diagnostics {
+		if !d.Warning {
+			msgs = append(msgs, d.String())
+		}
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// BrokenEntitiesError is returned by ParseEntities if some entity files
+// could not be parsed. The entities that could be parsed are returned
+// nonetheless.
+type BrokenEntitiesError struct {
+	Errors []error // one error per broken entity file
+}
+
+func (e *BrokenEntitiesError) Error() string {
+	return fmt.Sprintf("%d entity file(s) could not be parsed", len(e.Errors))
+}
+
+// GitError is returned when a git command fails. The go-git backend
+// describes its operations like git commands and reports the error
+// message as Stderr (see goGit).
+type GitError struct {
+	Dir      string // empty if git was not run in a repository
+	Args     []string
+	Err      error
+	Attempts int // how often the command was tried if it talked to a remote (see retryNetwork), else 0
+	Stderr   string
+	ExitCode int // as in gitResult
+}
+
+func (e *GitError) Error() string {
+	msg := "git " + strings.Join(e.Args, " ")
+	if e.Dir != "" {
+		msg += " (in " + e.Dir + ")"
+	}
+	detail := e.Err.Error()
+	if class := classifyGitError(e); class != FailureUnknown {
+		detail = failureClasses[class].name + " (" + detail + ")"
+	}
+	if e.Attempts > 1 {
+		return msg + " failed after " + strconv.Itoa(e.Attempts) + " attempts: " + detail
+	}
+	return msg + " failed: " + detail
+}
+
+func (e *GitError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a git command that talks to a remote
+// took too long (see networkPolicy).
+type TimeoutError struct {
+	After time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return "timed out after " + e.After.String()
+}
+
+// UnknownRevisionError is returned when a revision is not available in
+// a repository.
+type UnknownRevisionError struct {
+	Revision string
+	Path     string
+}
+
+func (e *UnknownRevisionError) Error() string {
+	return "revision '" + e.Revision + "' is not available in " + e.Path
+}
+
+// TargetConflictError is returned by Apply when the target path
+// already exists, differs from what the last apply left there and the
+// operation was not forced.
+type TargetConflictError struct {
+	Path   string
+	IsRepo bool
+	Reason string // why the target differs, e.g. "has uncommitted changes"
+}
+
+func (e *TargetConflictError) Error() string {
+	return e.Path + " " + e.Reason
+}
+
+// UnsafePathError is returned when holo-git-repos refuses to delete a
+// path because that could destroy data that is not ours.
+type UnsafePathError struct {
+	Path   string
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return "refusing to delete " + e.Path + ": " + e.Reason
+}
+
+// LockedError is returned when a lock could not be acquired in time
+// because another process holds it.
+type LockedError struct {
+	What string // what is locked, e.g. a target path
+	PID  int    // process holding the lock, 0 if unknown
+}
+
+func (e *LockedError) Error() string {
+	if e.PID == 0 {
+		return e.What + " is locked by another process"
+	}
+	return e.What + " is locked by PID " + strconv.Itoa(e.PID)
+}
@@ -0,0 +1,162 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// Failure is a class of failures the user can do something about (see
+// Classify). The holo-git-repos command exits with its own status for
+// each class.
+type Failure int
+
+const (
+	FailureUnknown Failure = iota
+	FailureAuth
+	FailureUnreachable
+	FailureNotFound
+	FailureUnknownRevision
+	FailurePermission
+	FailureDiskFull
+	FailureCorrupted
+)
+
+// failureClass describes a Failure. Failures of git commands are
+// recognized by what git prints to stderr, which is matched line by line
+// (ignoring case) against patterns. A '*' in a pattern stands for any
+// text within the line, e.g. the URL in git's messages.
+type failureClass struct {
+	name     string
+	hint     string
+	patterns []string
+}
+
+// failureClasses describes all classes but FailureUnknown.
+var failureClasses = map[Failure]failureClass{
+	FailureDiskFull: {"disk full",
+		"free up space on the file system of the target path",
+		[]string{"no space left on device", "disk quota exceeded"}},
+	FailureAuth: {"authentication failed",
+		"check the credentials of the user running holo for the remote, e.g. the SSH key or git credential helper",
+		[]string{"authentication failed", "could not read username", "could not read password", "permission denied (publickey",
+			"invalid username or password", "terminal prompts disabled", "returned error: 401", "returned error: 403",
+			"host key verification failed", "authentication required", "authorization failed"}},
+	FailureUnreachable: {"host unreachable",
+		"check the network connection and the host in the url, or set HOLO_GIT_REPOS_OFFLINE=true to use what was fetched before",
+		[]string{"could not resolve host", "could not resolve hostname", "connection refused", "timed out",
+			"network is unreachable", "no route to host", "failed to connect to", "couldn't connect to server",
+			"connection reset", "the remote end hung up unexpectedly", "no such host", "i/o timeout"}},
+	FailureNotFound: {"repository not found",
+		"check the url in the entity file",
+		[]string{"repository not found", "repository '*' not found", "repository '*' does not exist",
+			"does not appear to be a git repository", "returned error: 404"}},
+	FailureUnknownRevision: {"unknown revision",
+		"check the revision in the entity file, it must be a branch, tag or commit ID of the remote",
+		[]string{"did not match any file(s) known to git", "unknown revision", "reference is not a tree",
+			"not a valid object name", "couldn't find remote ref", "invalid reference", "reference not found"}},
+	FailureCorrupted: {"corrupted repository",
+		"the repository at the target path is damaged, run 'git fsck' in it or force-apply to clone it anew",
+		[]string{"corrupt", "bad object", "loose object", "object file", "bad tree", "unable to read tree",
+			"index file smaller than expected", "bad signature", "missing blob", "missing tree"}},
+	FailurePermission: {"permission denied",
+		"check that the user running holo may write to the target path and its parent directory",
+		[]string{"permission denied", "could not create work tree dir", "could not create leading directories",
+			"read-only file system", "insufficient permission"}},
+}
+
+func (f Failure) String() string {
+	if f == FailureUnknown {
+		return "unknown"
+	}
+	return failureClasses[f].name
+}
+
+// failureOrder is the order in which the classes are tried, i.e. more
+// specific patterns come first.
+var failureOrder = []Failure{FailureDiskFull, FailureAuth, FailureUnreachable, FailureNotFound,
+	FailureUnknownRevision, FailureCorrupted, FailurePermission}
+
+// classifyGitError returns the class of the failed git command.
+func classifyGitError(e *GitError) Failure {
+	var timeoutErr *TimeoutError
+	if errors.As(e.Err, &timeoutErr) {
+		return FailureUnreachable
+	}
+	lines := strings.Split(strings.ToLower(e.Stderr), "\n")
+	for _, class := range failureOrder {
+		for _, pattern := range failureClasses[class].patterns {
+			for _, line := range lines {
+				if matchFailurePattern(line, pattern) {
+					return class
+				}
+			}
+		}
+	}
+	return FailureUnknown
+}
+
+// matchFailurePattern reports whether line contains pattern, where a '*'
+// in pattern matches any text (see failureClass).
+func matchFailurePattern(line string, pattern string) bool {
+	for _, part := range strings.Split(pattern, "*") {
+		i := strings.Index(line, part)
+		if i < 0 {
+			return false
+		}
+		line = line[i+len(part):]
+	}
+	return true
+}
+
+// Classify returns the class of err. Failures of git commands are
+// classified by their output, other errors (e.g. when moving a clone into
+// place) by their errno.
+func Classify(err error) Failure {
+	var revisionErr *UnknownRevisionError
+	if errors.As(err, &revisionErr) {
+		return FailureUnknownRevision
+	}
+	var gitErr *GitError
+	if errors.As(err, &gitErr) {
+		if class := classifyGitError(gitErr); class != FailureUnknown {
+			return class
+		}
+	}
+	switch {
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		return FailureDiskFull
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM), errors.Is(err, syscall.EROFS):
+		return FailurePermission
+	}
+	return FailureUnknown
+}
+
+// FailureHint returns what the user can do about err, or emptystring if
+// we don't know.
+func FailureHint(err error) string {
+	class := Classify(err)
+	if class == FailureUnknown {
+		return ""
+	}
+	return failureClasses[class].hint
+}
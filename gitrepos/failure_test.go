@@ -0,0 +1,80 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyStderr(t *testing.T) {
+	cases := map[string]Failure{
+		"fatal: Authentication failed for 'https://example.com/repo.git/'":                        FailureAuth,
+		"git@example.com: Permission denied (publickey).":                                         FailureAuth,
+		"fatal: unable to access 'https://example.com/': Could not resolve host: example.com":     FailureUnreachable,
+		"ERROR: Repository not found.\nfatal: Could not read from remote repository.":             FailureNotFound,
+		"error: pathspec 'v9' did not match any file(s) known to git":                             FailureUnknownRevision,
+		"fatal: could not create work tree dir 'repo': Permission denied":                         FailurePermission,
+		"fatal: write error: No space left on device":                                             FailureDiskFull,
+		"error: object file .git/objects/ab/cdef is empty\nfatal: loose object abcdef is corrupt": FailureCorrupted,
+		"authentication required":                                     FailureAuth,
+		"dial tcp: lookup example.com on 127.0.0.53:53: no such host": FailureUnreachable,
+		"fatal: something else":                                       FailureUnknown,
+		"fatal: repository 'https://example.com/repo.git/' not found": FailureNotFound,
+		"fatal: repository '/srv/repo' does not exist":                FailureNotFound,
+		"fatal: Unable to create '/srv/repo/.git/index.lock': File exists.\n\nAnother git process seems to be running in this repository, e.g.\nan editor opened by 'git commit'. Please make sure all processes\nare terminated then try again. If it still fails, a git process\nmay have crashed in this repository earlier:\nremove the file manually to continue.": FailureUnknown,
+		"fatal: Unable to create '/srv/repo/.git/index.lock': Permission denied": FailurePermission,
+		"fatal: path 'docs' does not exist in 'HEAD'":                            FailureUnknown,
+		"fatal: repository\nnot found":                                           FailureUnknown,
+	}
+	for stderr, expected := range cases {
+		err := &GitError{Args: []string{"fetch"}, Err: errors.New("exit status 128"), Stderr: stderr}
+		assertEq(t, Classify(fmt.Errorf("wrapped: %w", err)), expected)
+	}
+}
+
+func TestClassifyErrno(t *testing.T) {
+	err := &os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.ENOSPC}
+	assertEq(t, Classify(err), FailureDiskFull)
+	err = &os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EACCES}
+	assertEq(t, Classify(err), FailurePermission)
+	assertEq(t, Classify(errors.New("other")), FailureUnknown)
+	assertEq(t, Classify(nil), FailureUnknown)
+	assertEq(t, FailureHint(errors.New("other")), "")
+}
+
+func TestClassifyGit(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := filepath.Join(t.TempDir(), "target")
+
+	err := clone(filepath.Join(t.TempDir(), "nonexistent"), target, "", networkPolicy{})
+	assertEq(t, Classify(err), FailureNotFound)
+	err = clone(source, target, "nonexistent-revision", networkPolicy{})
+	assertEq(t, Classify(err), FailureUnknownRevision)
+	assertEq(t, FailureHint(err), failureClasses[FailureUnknownRevision].hint)
+
+	repo := cloneTemporary(t, source)
+	err = update(repo, source, "nonexistent-revision", networkPolicy{offline: true})
+	assertEq(t, Classify(err), FailureUnknownRevision)
+}
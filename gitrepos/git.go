@@ -0,0 +1,413 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// execGit runs a git command with the given context (see runCmd) using
+// runner. If repoPath is not emptystring, git is run in that repository.
+// The output of the command is written to stdout, which may be nil to
+// discard it; errors are printed to stderr while git runs. Every command
+// is logged (see logGit). A failing command is reported as *GitError.
+func execGit(ctx context.Context, repoPath string, stdout io.Writer, arguments ...string) error {
+	start := time.Now()
+	result, err := runner.Run(ctx, repoPath, arguments)
+	logGit(repoPath, arguments, time.Since(start), result, err)
+	if stdout != nil {
+		io.WriteString(stdout, result.Stdout)
+	}
+	if err != nil {
+		return &GitError{Dir: repoPath, Args: arguments, Err: err, Stderr: result.Stderr, ExitCode: result.ExitCode}
+	}
+	return nil
+}
+
+// runGit builds and runs a git command.
+// If printOutput is true, the output of the command is printed to stdout.
+// A failing command is reported as *GitError.
+func runGit(printOutput bool, arguments ...string) error {
+	return runGitInDir(printOutput, "", arguments...)
+}
+
+// runGitInDir builds and runs a git command in an existing repository.
+// If printOutput is true, the output of the command is printed to stdout.
+func runGitInDir(printOutput bool, repoPath string, arguments ...string) error {
+	var stdout io.Writer
+	if printOutput {
+		stdout = os.Stdout
+	}
+	return execGit(gitContext, repoPath, stdout, arguments...)
+}
+
+// gitRawOutput runs a git command in an existing repository and returns
+// its output as it is. Errors are still printed to stderr. If repoPath
+// is emptystring, git is run in the current directory, which is enough
+// for commands like ls-remote.
+func gitRawOutput(repoPath string, arguments ...string) (string, error) {
+	var stdout bytes.Buffer
+	if err := execGit(gitContext, repoPath, &stdout, arguments...); err != nil {
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// gitOutput is like gitRawOutput, but returns the output without
+// surrounding whitespace.
+func gitOutput(repoPath string, arguments ...string) (string, error) {
+	out, err := gitRawOutput(repoPath, arguments...)
+	return strings.TrimSpace(out), err
+}
+
+// refExists checks whether ref exists in the git repository denoted by
+// path.
+func refExists(path string, ref string) bool {
+	_, err := currentBackend.Resolve(path, ref)
+	return err == nil
+}
+
+// resolveLocal resolves revision to a commit ID in the git repository
+// denoted by path without contacting the remote. Branches are resolved
+// from the remote-tracking branches, so that they mean the same as on
+// the remote. An empty revision means the remote's default branch.
+func resolveLocal(path string, revision string) (string, error) {
+	candidates := []string{"refs/remotes/origin/" + revision, revision}
+	if revision == "" {
+		candidates = []string{"refs/remotes/origin/HEAD"}
+	}
+	for _, candidate := range candidates {
+		commit, err := currentBackend.Resolve(path, candidate)
+		if err == nil {
+			return commit, nil
+		}
+	}
+	return "", &UnknownRevisionError{revision, path}
+}
+
+// commitIdPattern matches full (SHA-1) commit IDs.
+var commitIdPattern = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// remoteCommit asks the remote at url which commit revision points to,
+// without changing anything in the git repository denoted by path (which
+// may be emptystring).
+// An empty revision means the remote's default branch. ok is false if
+// the revision is neither a full commit ID nor a branch or tag of the
+// remote, e.g. an abbreviated commit ID. The remote is asked according
+// to the given network policy. In offline mode, the revision is resolved
+// from what was fetched last time instead (see resolveLocal).
+func remoteCommit(path string, url string, revision string, policy networkPolicy) (commit string, ok bool, err error) {
+	if commitIdPattern.MatchString(revision) {
+		return revision, true, nil
+	}
+	if policy.offline {
+		if path == "" {
+			return "", false, nil
+		}
+		commit, err := resolveLocal(path, revision)
+		return commit, err == nil, nil
+	}
+
+	refs := []string{"refs/heads/" + revision, "refs/tags/" + revision + "^{}", "refs/tags/" + revision}
+	if revision == "" {
+		refs = []string{"HEAD"}
+	}
+	var remoteRefs []gitRef
+	err = retryNetwork(policy, func(ctx context.Context) error {
+		var err error
+		remoteRefs, err = currentBackend.ListRemote(ctx, path, url, refs)
+		return err
+	})
+	if err != nil {
+		return "", false, err
+	}
+	found := make(map[string]string)
+	for _, ref := range remoteRefs {
+		found[ref.Name] = ref.Object
+	}
+	for _, ref := range refs { // a peeled tag takes precedence over the tag object
+		if commit, ok := found[ref]; ok {
+			return commit, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// isClean checks whether the worktree of the git repository denoted by
+// path has neither uncommitted changes nor untracked files.
+func isClean(path string) (bool, error) {
+	entries, err := currentBackend.Status(path)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) == 0, nil
+}
+
+// isGitRepo checks whether the given path is a git repository.
+// A path counts a git repository if it is a directory containing a .git directory
+func isGitRepo(path string) (bool, error) {
+	_, err := os.Stat(path + "/.git")
+	if err == nil {
+		return true, nil
+	}
+	// if we get this far, there is indeed a relevant error, unless path
+	// is a file
+	if !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTDIR) {
+		return false, fmt.Errorf("cannot stat %s: %w", path+"/.git/", err)
+	}
+	return false, nil
+}
+
+// stagingPattern returns the glob pattern of the staging directories
+// for clones into path.
+func stagingPattern(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".holo-clone-*")
+}
+
+// stageClone clones the git repo from url into a new staging directory
+// next to path, then checks out the given revision if it is not
+// emptystring and verifies the result. It returns the staging directory.
+// Since it is on the same file system as path, it can be renamed into
+// place atomically. Staging directories left behind by interrupted runs
+// are removed. The clone is timed out and retried according to policy.
+func stageClone(url string, path string, revision string, policy networkPolicy) (string, error) {
+	if policy.offline {
+		return "", fmt.Errorf("cannot clone %s while offline", url)
+	}
+
+	// We need to do clone and checkout separately, because
+	// revision can be a branch/tag name or a commit ID, so it
+	// can't reliably be specified to git-clone
+
+	// clean up after interrupted runs
+	leftovers, _ := filepath.Glob(stagingPattern(path))
+	for _, leftover := range leftovers {
+		os.RemoveAll(leftover)
+	}
+
+	// create staging directory
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("cannot create parent directory of %s: %w", path, err)
+	}
+	staging, err := ioutil.TempDir(filepath.Dir(path), "."+filepath.Base(path)+".holo-clone-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create staging directory for %s: %w", path, err)
+	}
+	// TempDir creates the directory with mode 0700, but the clone
+	// should look as if git had created it
+	if err := os.Chmod(staging, 0755); err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+
+	// clone, checkout and verify
+	err = retryNetwork(policy, func(ctx context.Context) error {
+		// a clone that was killed leaves its objects behind
+		if err := os.RemoveAll(staging); err != nil {
+			return err
+		}
+		if err := os.Mkdir(staging, 0755); err != nil {
+			return err
+		}
+		return currentBackend.Clone(ctx, url, staging)
+	})
+	if err == nil && revision != "" {
+		err = checkout(staging, revision)
+	}
+	if err == nil {
+		_, err = currentBackend.Resolve(staging, "HEAD")
+	}
+	if err != nil {
+		os.RemoveAll(staging)
+		return "", err
+	}
+	return staging, nil
+}
+
+// clone clones the git repo from url to path, then checks out the
+// given revision if it is not emptystring. An interrupted or failed clone
+// never leaves a half-populated path behind: the clone is prepared in a
+// staging directory (see stageClone) and only renamed to path once it is
+// complete.
+func clone(url string, path string, revision string, policy networkPolicy) error {
+	staging, err := stageClone(url, path, revision, policy)
+	if err != nil {
+		return err
+	}
+	return installStaged(staging, path)
+}
+
+// renameStaged moves a staged clone into place. Tests replace it to
+// make installing fail.
+var renameStaged = os.Rename
+
+// installStaged renames the complete clone in staging to path. The
+// staging directory is removed if that fails.
+func installStaged(staging string, path string) error {
+	if err := renameStaged(staging, path); err != nil {
+		os.RemoveAll(staging)
+		return fmt.Errorf("cannot move clone into place: %w", err)
+	}
+	return nil
+}
+
+// checkout checks out the given revision in the git repository
+// denoted by path.
+func checkout(path string, revision string) error {
+	return currentBackend.Checkout(path, revision)
+}
+
+// setOrigin points the remote origin of the git repository denoted by
+// path to url, adding the remote if necessary.
+func setOrigin(path string, url string) error {
+	current, err := originURL(path)
+	if err != nil || current == url {
+		return err
+	}
+	return currentBackend.SetRemoteURL(path, "origin", url)
+}
+
+// remoteDefaultBranch asks the remote origin of the git repository
+// denoted by path for its default branch and returns the branch name.
+// Asking the remote (instead of relying on what was recorded at clone
+// time) lets us follow a renamed default branch. In offline mode, the
+// default branch the remote had when we last asked is returned.
+func remoteDefaultBranch(path string, policy networkPolicy) (string, error) {
+	if !policy.offline {
+		err := retryNetwork(policy, func(ctx context.Context) error {
+			return currentBackend.UpdateRemoteHead(ctx, path)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	ref, err := currentBackend.SymbolicRef(path, "refs/remotes/origin/HEAD")
+	if err != nil {
+		return "", fmt.Errorf("default branch of %s is not known: %w", path, err)
+	}
+	return strings.TrimPrefix(ref, "origin/"), nil
+}
+
+// update fetches from url into the existing git repository denoted by
+// path and checks out the given revision.
+// If revision is emptystring, the remote's current default branch is
+// checked out. If revision is a branch of the remote, the local branch
+// of the same name is fast-forwarded to it, so that re-applying picks
+// up new upstream commits. Tags and commit IDs are checked out as they
+// are. Talking to the remote is timed out and retried according to
+// policy. A revision that is not available after fetching fails with
+// *UnknownRevisionError. In offline mode, nothing is fetched, so remote
+// branches are where they were when last fetched.
+func update(path string, url string, revision string, policy networkPolicy) error {
+
+	// fetch from the configured url
+	if err := setOrigin(path, url); err != nil {
+		return err
+	}
+	if !policy.offline {
+		err := retryNetwork(policy, func(ctx context.Context) error {
+			return currentBackend.Fetch(ctx, path)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// find out what to check out
+	if revision == "" {
+		var err error
+		if revision, err = remoteDefaultBranch(path, policy); err != nil {
+			return err
+		}
+	}
+	if _, err := resolveLocal(path, revision); err != nil {
+		return err
+	}
+	if !refExists(path, "refs/remotes/origin/"+revision) {
+		return checkout(path, revision)
+	}
+
+	// check out the branch and bring it up to date with its upstream
+	if err := currentBackend.CheckoutBranch(path, revision); err != nil {
+		return err
+	}
+	return currentBackend.FastForward(path, "origin/"+revision)
+}
+
+// updateSnapshot is what is needed to roll back a failed update.
+type updateSnapshot struct {
+	head   string
+	branch string // emptystring if HEAD is detached
+	origin string // emptystring if there is no origin
+	backup string // timestamp of the backup made after the snapshot, if any (see backup)
+}
+
+// snapshotForRollback records HEAD, branch and origin of the git
+// repository denoted by path before it is updated.
+func snapshotForRollback(path string) (updateSnapshot, error) {
+	var s updateSnapshot
+	var err error
+	if s.head, err = currentBackend.Resolve(path, "HEAD"); err != nil {
+		return s, err
+	}
+	s.branch, _ = currentBackend.SymbolicRef(path, "HEAD")
+	s.origin, _ = originURL(path)
+	return s, nil
+}
+
+// rollbackUpdate puts the git repository denoted by path back into the
+// state recorded in s, after an update failed or was interrupted.
+// Branches that the update fast-forwarded are reset, origin points to
+// its old url again, and the changes saved in the backup are put back
+// into the worktree. The git commands used for this cannot
+// be cancelled.
+func rollbackUpdate(path string, s updateSnapshot) error {
+	return withoutCancellation(func() error {
+		// the interrupted update may have left changes behind, which
+		// are discarded here; the user's changes are in the backup
+		if err := currentBackend.ForceCheckout(path, s.branch, s.head); err != nil {
+			return err
+		}
+		if s.backup != "" {
+			backups, err := backupRefs(path)
+			if err != nil {
+				return err
+			}
+			if err := restoreBackupWorktree(path, s.backup, backups[s.backup]); err != nil {
+				return err
+			}
+		}
+		if s.origin != "" {
+			return setOrigin(path, s.origin)
+		}
+		return nil
+	})
+}
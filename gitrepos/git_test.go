@@ -0,0 +1,111 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// cloneTemporary clones url into a new temporary directory and returns its path.
+func cloneTemporary(t *testing.T, url string) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	target := path.Join(dir, "repo")
+	assertErrNil(t, runGit(false, "clone", "--quiet", url, target), "Cannot clone "+url)
+	return target
+}
+
+// update with a branch revision picks up new upstream commits
+func TestUpdateBranch(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	newCommit := makeTemporaryCommit(t, source)
+
+	assertErrNil(t, update(target, source, "main", networkPolicy{}), "Update failed")
+	assertEq(t, gitHead(t, target), newCommit)
+}
+
+// update with an empty revision follows a renamed default branch
+func TestUpdateDefaultBranchRename(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	assertErrNil(t, runGitInDir(false, source, "branch", "-m", "main", "trunk"), "Cannot rename branch")
+	newCommit := makeTemporaryCommit(t, source)
+
+	assertErrNil(t, update(target, source, "", networkPolicy{}), "Update failed")
+	assertEq(t, gitHead(t, target), newCommit)
+	branch, err := gitOutput(target, "symbolic-ref", "--short", "HEAD")
+	assertErrNil(t, err, "Cannot get current branch")
+	assertEq(t, branch, "trunk")
+}
+
+// update points origin to the new url
+func TestUpdateUrlChange(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	mirror := cloneTemporary(t, source)
+	target := cloneTemporary(t, mirror)
+	makeTemporaryCommit(t, source)
+
+	assertErrNil(t, update(target, source, "main", networkPolicy{}), "Update failed")
+	url, err := gitOutput(target, "remote", "get-url", "origin")
+	assertErrNil(t, err, "Cannot get url of origin")
+	assertEq(t, url, source)
+	assertEq(t, gitHead(t, target), gitHead(t, source))
+}
+
+func TestRemoteCommit(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	head := gitHead(t, source)
+	assertErrNil(t, runGitInDir(false, source, "-c", "user.name=test", "-c", "user.email=test@example.com", "tag", "-a", "-m", "tag", "v1"), "Cannot tag")
+
+	for _, revision := range []string{"", "main", "v1", head} {
+		commit, ok, err := remoteCommit(source, source, revision, networkPolicy{})
+		assertErrNil(t, err, "Cannot ask remote")
+		assertEq(t, ok, true)
+		assertEq(t, commit, head)
+	}
+	_, ok, err := remoteCommit(source, source, head[:7], networkPolicy{})
+	assertErrNil(t, err, "Cannot ask remote")
+	assertEq(t, ok, false)
+}
+
+// a failing checkout leaves neither the target nor a staging directory behind
+func TestCloneFailureLeavesNothing(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	target := path.Join(dir, "repo")
+
+	assertEq(t, clone(source, target, "nonexistent-revision", networkPolicy{}) != nil, true)
+	entries, err := ioutil.ReadDir(dir)
+	assertErrNil(t, err, "Cannot read directory")
+	assertEq(t, len(entries), 0)
+
+	// leftovers of interrupted runs are cleaned up
+	assertErrNil(t, os.Mkdir(path.Join(dir, ".repo.holo-clone-123"), 0755), "Cannot create leftover")
+	assertErrNil(t, clone(source, target, "", networkPolicy{}), "Clone failed")
+	entries, err = ioutil.ReadDir(dir)
+	assertErrNil(t, err, "Cannot read directory")
+	assertEq(t, len(entries), 1)
+	assertEq(t, entries[0].Name(), "repo")
+}
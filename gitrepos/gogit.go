@@ -0,0 +1,1091 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// goGitBackend implements the git operations in process with go-git, so
+// that no git binary is needed. Unlike git, it refuses to check out
+// other commits while there are uncommitted changes.
+type goGitBackend struct{}
+
+// goGit runs the operation f, which is described by arguments like a git
+// command, and logs it. Errors are reported as *GitError, with the error
+// message in place of git's stderr, so that they can be classified.
+func goGit(path string, arguments []string, f func() error) error {
+	start := time.Now()
+	err := f()
+	if logEnabled(LogDebug) {
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		Logf(LogDebug, "go-git op=%q dir=%q duration=%s error=%q", strings.Join(arguments, " "), path, time.Since(start), msg)
+	}
+	if err != nil {
+		return &GitError{Dir: path, Args: arguments, Err: err, Stderr: err.Error(), ExitCode: 1}
+	}
+	return nil
+}
+
+// openWorktree opens the repository denoted by path and its worktree.
+func openWorktree(path string) (*git.Repository, *git.Worktree, error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	w, err := r.Worktree()
+	return r, w, err
+}
+
+// requireNoChanges fails if tracked files in the worktree w have
+// changes. go-git would discard them or move HEAD without updating the
+// worktree when checking out.
+func requireNoChanges(w *git.Worktree) error {
+	status, err := w.Status()
+	if err != nil {
+		return err
+	}
+	for _, s := range status {
+		if s.Worktree != git.Untracked && (s.Worktree != git.Unmodified || s.Staging != git.Unmodified) {
+			return errors.New("the worktree contains uncommitted changes")
+		}
+	}
+	return nil
+}
+
+// remoteHeadRef is where the default branch of origin is recorded.
+const remoteHeadRef = plumbing.ReferenceName("refs/remotes/origin/HEAD")
+
+func (goGitBackend) Clone(ctx context.Context, url string, path string) error {
+	return goGit("", []string{"clone", url, path}, func() error {
+		r, err := git.PlainCloneContext(ctx, path, false, &git.CloneOptions{URL: url, Tags: git.AllTags})
+		if err != nil {
+			return err
+		}
+		// like git, remember the default branch of the remote
+		head, err := r.Head()
+		if err != nil || !head.Name().IsBranch() {
+			return err
+		}
+		target := plumbing.NewRemoteReferenceName("origin", head.Name().Short())
+		return r.Storer.SetReference(plumbing.NewSymbolicReference(remoteHeadRef, target))
+	})
+}
+
+func (goGitBackend) Fetch(ctx context.Context, path string) error {
+	return goGit(path, []string{"fetch", "origin"}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		err = r.FetchContext(ctx, &git.FetchOptions{RemoteName: "origin", Tags: git.AllTags, Prune: true})
+		if err == git.NoErrAlreadyUpToDate {
+			return nil
+		}
+		return err
+	})
+}
+
+// listRemote lists the refs of remote, resolving symbolic refs like HEAD
+// to what they point to.
+func listRemote(ctx context.Context, remote *git.Remote) ([]*plumbing.Reference, error) {
+	refs, err := remote.ListContext(ctx, &git.ListOptions{PeelingOption: git.AppendPeeled})
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[plumbing.ReferenceName]*plumbing.Reference)
+	for _, ref := range refs {
+		byName[ref.Name()] = ref
+	}
+	for i, ref := range refs {
+		if ref.Type() == plumbing.SymbolicReference {
+			if target, ok := byName[ref.Target()]; ok {
+				refs[i] = plumbing.NewHashReference(ref.Name(), target.Hash())
+			}
+		}
+	}
+	return refs, nil
+}
+
+func (goGitBackend) ListRemote(ctx context.Context, path string, url string, names []string) ([]gitRef, error) {
+	var result []gitRef
+	err := goGit(path, []string{"ls-remote", url}, func() error {
+		remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{url}})
+		refs, err := listRemote(ctx, remote)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if ref.Type() == plumbing.HashReference {
+				result = append(result, gitRef{Name: ref.Name().String(), Object: ref.Hash().String()})
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+func (goGitBackend) UpdateRemoteHead(ctx context.Context, path string) error {
+	return goGit(path, []string{"remote", "set-head", "origin", "--auto"}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		remote, err := r.Remote("origin")
+		if err != nil {
+			return err
+		}
+		refs, err := remote.ListContext(ctx, &git.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference && ref.Target().IsBranch() {
+				target := plumbing.NewRemoteReferenceName("origin", ref.Target().Short())
+				return r.Storer.SetReference(plumbing.NewSymbolicReference(remoteHeadRef, target))
+			}
+		}
+		return errors.New("cannot determine the default branch of origin")
+	})
+}
+
+func (b goGitBackend) Checkout(path string, revision string) error {
+	return goGit(path, []string{"checkout", revision}, func() error {
+		r, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		if err := requireNoChanges(w); err != nil {
+			return err
+		}
+		branch := plumbing.NewBranchReferenceName(revision)
+		if _, err := r.Reference(branch, false); err == nil {
+			return w.Checkout(&git.CheckoutOptions{Branch: branch})
+		}
+		// like git, create a local branch for a remote-tracking branch
+		if _, err := r.Reference(plumbing.NewRemoteReferenceName("origin", revision), false); err == nil {
+			return checkoutBranch(r, w, revision)
+		}
+		hash, err := r.ResolveRevision(plumbing.Revision(revision))
+		if err != nil {
+			return fmt.Errorf("pathspec '%s' did not match any file(s) known to git: %w", revision, err)
+		}
+		return w.Checkout(&git.CheckoutOptions{Hash: *hash})
+	})
+}
+
+// checkoutBranch checks out the local branch, creating it from the
+// remote-tracking branch of origin and tracking that if necessary.
+func checkoutBranch(r *git.Repository, w *git.Worktree, branch string) error {
+	local := plumbing.NewBranchReferenceName(branch)
+	if _, err := r.Reference(local, false); err != nil {
+		remote, err := r.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
+		if err != nil {
+			return err
+		}
+		if err := r.Storer.SetReference(plumbing.NewHashReference(local, remote.Hash())); err != nil {
+			return err
+		}
+		err = r.CreateBranch(&config.Branch{Name: branch, Remote: "origin", Merge: local})
+		if err != nil && err != git.ErrBranchExists {
+			return err
+		}
+	}
+	return w.Checkout(&git.CheckoutOptions{Branch: local})
+}
+
+func (goGitBackend) CheckoutBranch(path string, branch string) error {
+	return goGit(path, []string{"checkout", branch}, func() error {
+		r, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		if err := requireNoChanges(w); err != nil {
+			return err
+		}
+		return checkoutBranch(r, w, branch)
+	})
+}
+
+func (goGitBackend) FastForward(path string, revision string) error {
+	return goGit(path, []string{"merge", "--ff-only", revision}, func() error {
+		r, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		target, err := r.ResolveRevision(plumbing.Revision(revision))
+		if err != nil {
+			return err
+		}
+		if head.Hash() == *target {
+			return nil
+		}
+		headCommit, err := r.CommitObject(head.Hash())
+		if err != nil {
+			return err
+		}
+		targetCommit, err := r.CommitObject(*target)
+		if err != nil {
+			return err
+		}
+		if ok, err := headCommit.IsAncestor(targetCommit); err != nil || !ok {
+			if err == nil {
+				err = errors.New("not possible to fast-forward")
+			}
+			return err
+		}
+		// MergeReset moves the checked out branch and refuses to
+		// overwrite uncommitted changes
+		return w.Reset(&git.ResetOptions{Commit: *target, Mode: git.MergeReset})
+	})
+}
+
+func (goGitBackend) ForceCheckout(path string, branch string, commit string) error {
+	return goGit(path, []string{"checkout", "--force", branch, commit}, func() error {
+		r, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		hash := plumbing.NewHash(commit)
+		head := plumbing.NewHashReference(plumbing.HEAD, hash)
+		if branch != "" {
+			local := plumbing.NewBranchReferenceName(branch)
+			if err := r.Storer.SetReference(plumbing.NewHashReference(local, hash)); err != nil {
+				return err
+			}
+			head = plumbing.NewSymbolicReference(plumbing.HEAD, local)
+		}
+		if err := r.Storer.SetReference(head); err != nil {
+			return err
+		}
+		return w.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+	})
+}
+
+func (goGitBackend) Status(path string) ([]statusEntry, error) {
+	var entries []statusEntry
+	err := goGit(path, []string{"status"}, func() error {
+		_, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		status, err := w.Status()
+		if err != nil {
+			return err
+		}
+		for file, s := range status {
+			if s.Staging == git.Unmodified && s.Worktree == git.Unmodified {
+				continue
+			}
+			entries = append(entries, statusEntry{byte(s.Staging), byte(s.Worktree), file, s.Extra})
+		}
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, err
+}
+
+func (goGitBackend) Diff(path string, commit string, w io.Writer) error {
+	return goGit(path, []string{"diff", commit}, func() error {
+		r, wt, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		hash, err := r.ResolveRevision(plumbing.Revision(commit))
+		if err != nil {
+			return err
+		}
+		c, err := r.CommitObject(*hash)
+		if err != nil {
+			return err
+		}
+		from, err := c.Tree()
+		if err != nil {
+			return err
+		}
+		to, err := worktreeTree(r, wt, path)
+		if err != nil {
+			return err
+		}
+		changes, err := object.DiffTree(from, to)
+		if err != nil {
+			return err
+		}
+		patch, err := changes.Patch()
+		if err != nil {
+			return err
+		}
+		return patch.Encode(w)
+	})
+}
+
+// overlayStorer stores objects in memory, but also finds the objects of
+// the repository it overlays.
+type overlayStorer struct {
+	*memory.ObjectStorage
+	base storer.EncodedObjectStorer
+}
+
+func (s overlayStorer) EncodedObject(t plumbing.ObjectType, h plumbing.Hash) (plumbing.EncodedObject, error) {
+	obj, err := s.ObjectStorage.EncodedObject(t, h)
+	if err == plumbing.ErrObjectNotFound {
+		return s.base.EncodedObject(t, h)
+	}
+	return obj, err
+}
+
+// treeNode is a directory while building a tree object.
+type treeNode struct {
+	entries []object.TreeEntry
+	dirs    map[string]*treeNode
+}
+
+// add adds entry to the tree below the slash separated path.
+func (n *treeNode) add(path string, entry object.TreeEntry) {
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		entry.Name = path
+		n.entries = append(n.entries, entry)
+		return
+	}
+	if n.dirs == nil {
+		n.dirs = make(map[string]*treeNode)
+	}
+	child, ok := n.dirs[parts[0]]
+	if !ok {
+		child = &treeNode{}
+		n.dirs[parts[0]] = child
+	}
+	child.add(parts[1], entry)
+}
+
+// write stores the tree and its subtrees in s and returns its hash.
+func (n *treeNode) write(s storer.EncodedObjectStorer) (plumbing.Hash, error) {
+	entries := n.entries
+	for name, child := range n.dirs {
+		hash, err := child.write(s)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+	// git sorts directories as if their names ended with a slash
+	sortKey := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortKey(entries[i]) < sortKey(entries[j]) })
+
+	obj := s.NewEncodedObject()
+	if err := (&object.Tree{Entries: entries}).Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return s.SetEncodedObject(obj)
+}
+
+// worktreeTree returns a tree object with the contents of the worktree
+// of r, i.e. the tracked files (as they are on disk) and the untracked
+// files. Blobs and trees that are not in r yet are only kept in memory,
+// nothing is written to the repository.
+func worktreeTree(r *git.Repository, w *git.Worktree, path string) (*object.Tree, error) {
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return nil, err
+	}
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]filemode.FileMode)
+	for _, e := range idx.Entries {
+		files[e.Name] = e.Mode
+	}
+	for file, s := range status {
+		if s.Worktree == git.Untracked {
+			files[file] = filemode.Regular
+		}
+	}
+
+	mem := memory.NewStorage()
+	s := overlayStorer{&mem.ObjectStorage, r.Storer}
+	root := &treeNode{}
+	for _, e := range idx.Entries {
+		if e.Mode == filemode.Submodule {
+			root.add(e.Name, object.TreeEntry{Mode: e.Mode, Hash: e.Hash})
+			delete(files, e.Name)
+		}
+	}
+	for file := range files {
+		entry, ok, err := worktreeEntry(s, filepath.Join(path, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		if ok { // deleted files are left out
+			root.add(file, entry)
+		}
+	}
+	hash, err := root.write(s)
+	if err != nil {
+		return nil, err
+	}
+	return object.GetTree(s, hash)
+}
+
+// worktreeEntry stores the file at path as a blob in s unless it already
+// is there and returns its tree entry. ok is false if the file does not
+// exist.
+func worktreeEntry(s storer.EncodedObjectStorer, path string) (entry object.TreeEntry, ok bool, err error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+
+	var content []byte
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		entry.Mode = filemode.Symlink
+		target, err := os.Readlink(path)
+		if err != nil {
+			return entry, false, err
+		}
+		content = []byte(target)
+	case info.Mode().IsRegular():
+		entry.Mode = filemode.Regular
+		if info.Mode()&0111 != 0 {
+			entry.Mode = filemode.Executable
+		}
+		if content, err = ioutil.ReadFile(path); err != nil {
+			return entry, false, err
+		}
+	default:
+		return entry, false, nil
+	}
+
+	entry.Hash = plumbing.ComputeHash(plumbing.BlobObject, content)
+	if _, err := s.EncodedObject(plumbing.BlobObject, entry.Hash); err == nil {
+		return entry, true, nil
+	}
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	writer, err := obj.Writer()
+	if err != nil {
+		return entry, false, err
+	}
+	if _, err := writer.Write(content); err != nil {
+		return entry, false, err
+	}
+	if err := writer.Close(); err != nil {
+		return entry, false, err
+	}
+	_, err = s.SetEncodedObject(obj)
+	return entry, true, err
+}
+
+func (goGitBackend) Resolve(path string, revision string) (string, error) {
+	var commit string
+	err := goGit(path, []string{"rev-parse", revision}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		hash, err := r.ResolveRevision(plumbing.Revision(revision))
+		if err != nil {
+			return err
+		}
+		commit = hash.String()
+		return nil
+	})
+	return commit, err
+}
+
+func (goGitBackend) SymbolicRef(path string, name string) (string, error) {
+	var target string
+	err := goGit(path, []string{"symbolic-ref", name}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		ref, err := r.Reference(plumbing.ReferenceName(name), false)
+		if err != nil {
+			return err
+		}
+		if ref.Type() != plumbing.SymbolicReference {
+			return fmt.Errorf("%s is not a symbolic ref", name)
+		}
+		target = ref.Target().Short()
+		return nil
+	})
+	return target, err
+}
+
+func (goGitBackend) RemoteURLs(path string) (map[string]string, error) {
+	urls := make(map[string]string)
+	err := goGit(path, []string{"config", "remote.*.url"}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		for name, remote := range cfg.Remotes {
+			if len(remote.URLs) > 0 {
+				urls[name] = remote.URLs[0]
+			}
+		}
+		return nil
+	})
+	return urls, err
+}
+
+func (goGitBackend) SetRemoteURL(path string, name string, url string) error {
+	return goGit(path, []string{"remote", "set-url", name, url}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+		if remote, ok := cfg.Remotes[name]; ok {
+			remote.URLs = []string{url}
+		} else {
+			cfg.Remotes[name] = &config.RemoteConfig{
+				Name:  name,
+				URLs:  []string{url},
+				Fetch: []config.RefSpec{config.RefSpec("+refs/heads/*:refs/remotes/" + name + "/*")},
+			}
+		}
+		return r.SetConfig(cfg)
+	})
+}
+
+// ancestors returns the commit with the given hash and all its
+// ancestors.
+func ancestors(r *git.Repository, hash plumbing.Hash) (map[plumbing.Hash]*object.Commit, error) {
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	commits := make(map[plumbing.Hash]*object.Commit)
+	err = object.NewCommitPreorderIter(c, nil, nil).ForEach(func(c *object.Commit) error {
+		commits[c.Hash] = c
+		return nil
+	})
+	return commits, err
+}
+
+// headAndCommit returns the ancestors of HEAD and of commit in the
+// repository denoted by path.
+func headAndCommit(path string, commit string) (head map[plumbing.Hash]*object.Commit, other map[plumbing.Hash]*object.Commit, err error) {
+	r, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	headRef, err := r.Head()
+	if err != nil {
+		return nil, nil, err
+	}
+	hash, err := r.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		return nil, nil, err
+	}
+	if head, err = ancestors(r, headRef.Hash()); err != nil {
+		return nil, nil, err
+	}
+	other, err = ancestors(r, *hash)
+	return head, other, err
+}
+
+func (goGitBackend) Log(path string, commit string) ([]string, error) {
+	var lines []string
+	err := goGit(path, []string{"log", "HEAD.." + commit}, func() error {
+		head, other, err := headAndCommit(path, commit)
+		if err != nil {
+			return err
+		}
+		var incoming []*object.Commit
+		for hash, c := range other {
+			if _, ok := head[hash]; !ok {
+				incoming = append(incoming, c)
+			}
+		}
+		sort.Slice(incoming, func(i, j int) bool { return incoming[i].Committer.When.After(incoming[j].Committer.When) })
+		for _, c := range incoming {
+			subject := strings.SplitN(strings.TrimSpace(c.Message), "\n", 2)[0]
+			lines = append(lines, c.Hash.String()[:7]+" "+subject)
+		}
+		return nil
+	})
+	return lines, err
+}
+
+func (goGitBackend) AheadBehind(path string, commit string) (ahead int, behind int, err error) {
+	err = goGit(path, []string{"rev-list", "--count", commit + "...HEAD"}, func() error {
+		head, other, err := headAndCommit(path, commit)
+		if err != nil {
+			return err
+		}
+		for hash := range head {
+			if _, ok := other[hash]; !ok {
+				ahead++
+			}
+		}
+		for hash := range other {
+			if _, ok := head[hash]; !ok {
+				behind++
+			}
+		}
+		return nil
+	})
+	return ahead, behind, err
+}
+
+func (goGitBackend) ListRefs(path string, prefix string) ([]gitRef, error) {
+	var refs []gitRef
+	err := goGit(path, []string{"for-each-ref", prefix}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		iter, err := r.References()
+		if err != nil {
+			return err
+		}
+		return iter.ForEach(func(ref *plumbing.Reference) error {
+			if ref.Type() == plumbing.HashReference && strings.HasPrefix(ref.Name().String(), prefix) {
+				refs = append(refs, gitRef{Name: ref.Name().String(), Object: ref.Hash().String()})
+			}
+			return nil
+		})
+	})
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, err
+}
+
+func (goGitBackend) UpdateRef(path string, name string, commit string) error {
+	return goGit(path, []string{"update-ref", name, commit}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		return r.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), plumbing.NewHash(commit)))
+	})
+}
+
+func (goGitBackend) DeleteRef(path string, name string) error {
+	return goGit(path, []string{"update-ref", "-d", name}, func() error {
+		r, err := git.PlainOpen(path)
+		if err != nil {
+			return err
+		}
+		return r.Storer.RemoveReference(plumbing.ReferenceName(name))
+	})
+}
+
+// writeCommit stores a commit of tree with the given parents and message
+// in r and returns its hash.
+func writeCommit(r *git.Repository, tree plumbing.Hash, parents []plumbing.Hash, message string) (plumbing.Hash, error) {
+	signature := object.Signature{Name: backupName, Email: backupEmail, When: time.Now()}
+	c := &object.Commit{Author: signature, Committer: signature, Message: message, TreeHash: tree, ParentHashes: parents}
+	obj := r.Storer.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return r.Storer.SetEncodedObject(obj)
+}
+
+// removeFile removes the file below dir and the directories it leaves
+// empty.
+func removeFile(dir string, file string) error {
+	if err := os.Remove(filepath.Join(dir, filepath.FromSlash(file))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for parent := path.Dir(file); parent != "."; parent = path.Dir(parent) {
+		if os.Remove(filepath.Join(dir, filepath.FromSlash(parent))) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Stash writes the commits git stash would write: the index (I) with
+// parent HEAD, the untracked files (U) without parent if there are any,
+// and the tracked files as they are on disk (W) with parents HEAD, I and
+// U.
+func (goGitBackend) Stash(path string, message string) (string, error) {
+	var commit string
+	err := goGit(path, []string{"stash", "push", "--include-untracked", "-m", message}, func() error {
+		r, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		status, err := w.Status()
+		if err != nil {
+			return err
+		}
+		var untracked []string
+		changed := false
+		for file, s := range status {
+			if s.Worktree == git.Untracked {
+				untracked = append(untracked, file)
+			} else if s.Staging != git.Unmodified || s.Worktree != git.Unmodified {
+				changed = true
+			}
+		}
+		if !changed && len(untracked) == 0 {
+			return nil
+		}
+
+		idx, err := r.Storer.Index()
+		if err != nil {
+			return err
+		}
+		index, worktree := &treeNode{}, &treeNode{}
+		for _, e := range idx.Entries {
+			entry := object.TreeEntry{Mode: e.Mode, Hash: e.Hash}
+			index.add(e.Name, entry)
+			if e.Mode != filemode.Submodule {
+				var ok bool
+				entry, ok, err = worktreeEntry(r.Storer, filepath.Join(path, filepath.FromSlash(e.Name)))
+				if err != nil {
+					return err
+				}
+				if !ok { // deleted files are left out
+					continue
+				}
+			}
+			worktree.add(e.Name, entry)
+		}
+		indexTree, err := index.write(r.Storer)
+		if err != nil {
+			return err
+		}
+		indexCommit, err := writeCommit(r, indexTree, []plumbing.Hash{head.Hash()}, "index on "+message)
+		if err != nil {
+			return err
+		}
+		parents := []plumbing.Hash{head.Hash(), indexCommit}
+		if len(untracked) > 0 {
+			files := &treeNode{}
+			for _, file := range untracked {
+				entry, ok, err := worktreeEntry(r.Storer, filepath.Join(path, filepath.FromSlash(file)))
+				if err != nil {
+					return err
+				}
+				if ok {
+					files.add(file, entry)
+				}
+			}
+			untrackedTree, err := files.write(r.Storer)
+			if err != nil {
+				return err
+			}
+			untrackedCommit, err := writeCommit(r, untrackedTree, nil, "untracked files on "+message)
+			if err != nil {
+				return err
+			}
+			parents = append(parents, untrackedCommit)
+		}
+		worktreeTree, err := worktree.write(r.Storer)
+		if err != nil {
+			return err
+		}
+		stash, err := writeCommit(r, worktreeTree, parents, message)
+		if err != nil {
+			return err
+		}
+
+		// like git stash, leave a clean worktree behind; untracked files
+		// go first, because some may be tracked by HEAD
+		for _, file := range untracked {
+			if err := removeFile(path, file); err != nil {
+				return err
+			}
+		}
+		if err := w.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+			return err
+		}
+		commit = stash.String()
+		return nil
+	})
+	return commit, err
+}
+
+// treeEntries returns the entries of tree and its subtrees by path,
+// leaving out the subtrees themselves.
+func treeEntries(tree *object.Tree) (map[string]object.TreeEntry, error) {
+	entries := make(map[string]object.TreeEntry)
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode != filemode.Dir {
+			entries[name] = entry
+		}
+	}
+}
+
+// commitEntries returns the entries of the tree of the commit with the
+// given hash (see treeEntries).
+func commitEntries(r *git.Repository, hash plumbing.Hash) (map[string]object.TreeEntry, error) {
+	c, err := r.CommitObject(hash)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+	return treeEntries(tree)
+}
+
+// writeFiles writes the files in entries into the directory dir,
+// replacing what is there. Submodules are left alone.
+func writeFiles(r *git.Repository, dir string, entries map[string]object.TreeEntry) error {
+	for name, entry := range entries {
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+		blob, err := r.BlobObject(entry.Hash)
+		if err != nil {
+			return err
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		switch entry.Mode {
+		case filemode.Symlink:
+			err = os.Symlink(string(content), file)
+		case filemode.Executable:
+			err = ioutil.WriteFile(file, content, 0755)
+		default:
+			err = ioutil.WriteFile(file, content, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (goGitBackend) ApplyStash(path string, commit string) error {
+	return goGit(path, []string{"stash", "apply", "--index", commit}, func() error {
+		r, w, err := openWorktree(path)
+		if err != nil {
+			return err
+		}
+		stash, err := r.CommitObject(plumbing.NewHash(commit))
+		if err != nil {
+			return err
+		}
+		if len(stash.ParentHashes) < 2 {
+			return fmt.Errorf("%s is not a stash commit", commit)
+		}
+		head, err := r.Head()
+		if err != nil {
+			return err
+		}
+		// unlike git, don't merge the changes into another commit
+		if head.Hash() != stash.ParentHashes[0] {
+			return fmt.Errorf("%s was stashed on %s, not on HEAD", commit, stash.ParentHashes[0])
+		}
+		if err := requireNoChanges(w); err != nil {
+			return err
+		}
+
+		// the tracked files as they were on disk
+		base, err := commitEntries(r, stash.ParentHashes[0])
+		if err != nil {
+			return err
+		}
+		saved, err := commitEntries(r, stash.Hash)
+		if err != nil {
+			return err
+		}
+		for name, entry := range base {
+			if _, ok := saved[name]; !ok && entry.Mode != filemode.Submodule {
+				if err := removeFile(path, name); err != nil {
+					return err
+				}
+			}
+		}
+		if err := writeFiles(r, path, saved); err != nil {
+			return err
+		}
+
+		// the index
+		staged, err := commitEntries(r, stash.ParentHashes[1])
+		if err != nil {
+			return err
+		}
+		idx := &index.Index{Version: 2}
+		for name, entry := range staged {
+			idx.Entries = append(idx.Entries, &index.Entry{Name: name, Mode: entry.Mode, Hash: entry.Hash})
+		}
+		sort.Slice(idx.Entries, func(i, j int) bool { return idx.Entries[i].Name < idx.Entries[j].Name })
+		if err := r.Storer.SetIndex(idx); err != nil {
+			return err
+		}
+
+		// the untracked files
+		if len(stash.ParentHashes) < 3 {
+			return nil
+		}
+		untracked, err := commitEntries(r, stash.ParentHashes[2])
+		if err != nil {
+			return err
+		}
+		return writeFiles(r, path, untracked)
+	})
+}
+
+// repoLoader finds the repositories that the in-process server serves
+// for local paths and file:// URLs. Unlike server.DefaultLoader, it
+// looks for the objects of repositories with a worktree in .git.
+type repoLoader struct{}
+
+func (repoLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
+	dir := ep.Path
+	if info, err := os.Stat(filepath.Join(dir, ".git")); err == nil && info.IsDir() {
+		dir = filepath.Join(dir, ".git")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "objects")); err != nil {
+		return nil, transport.ErrRepositoryNotFound
+	}
+	return filesystem.NewStorage(osfs.New(dir), cache.NewObjectLRUDefault()), nil
+}
+
+// localServer serves local repositories in process like git-upload-pack
+// does, which go-git runs for them by default.
+type localServer struct {
+	transport.Transport
+}
+
+func (s localServer) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	session, err := s.Transport.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := repoLoader{}.Load(ep)
+	return peelingSession{session, repo}, err
+}
+
+// peelingSession adds the peeled tags to the advertised refs, which
+// go-git's server leaves out (see ListRemote).
+type peelingSession struct {
+	transport.UploadPackSession
+	repo storer.Storer
+}
+
+func (s peelingSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return s.AdvertisedReferencesContext(context.TODO())
+}
+
+func (s peelingSession) AdvertisedReferencesContext(ctx context.Context) (*packp.AdvRefs, error) {
+	refs, err := s.UploadPackSession.AdvertisedReferencesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for name, hash := range refs.References {
+		if !strings.HasPrefix(name, "refs/tags/") {
+			continue
+		}
+		target := hash
+		for {
+			tag, err := object.GetTag(s.repo, target)
+			if err != nil {
+				break
+			}
+			target = tag.Target
+		}
+		if target != hash {
+			refs.Peeled[name] = target
+		}
+	}
+	return refs, nil
+}
+
+func init() {
+	client.InstallProtocol("file", localServer{server.NewServer(repoLoader{})})
+}
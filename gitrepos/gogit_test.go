@@ -0,0 +1,201 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+// useBackend makes b the current backend and returns a function that
+// restores the previous one.
+func useBackend(b backend) (restore func()) {
+	saved := currentBackend
+	currentBackend = b
+	return func() { currentBackend = saved }
+}
+
+func TestSetupBackend(t *testing.T) {
+	defer useBackend(currentBackend)()
+	defer os.Unsetenv("HOLO_GIT_REPOS_BACKEND")
+
+	os.Setenv("HOLO_GIT_REPOS_BACKEND", "go-git")
+	assertErrNil(t, SetupBackend(), "Cannot select go-git")
+	assertEq(t, currentBackend, backend(goGitBackend{}))
+	os.Setenv("HOLO_GIT_REPOS_BACKEND", "")
+	assertErrNil(t, SetupBackend(), "Cannot select the default")
+	assertEq(t, currentBackend, backend(execBackend{}))
+	os.Setenv("HOLO_GIT_REPOS_BACKEND", "libgit2")
+	assertEq(t, SetupBackend() != nil, true)
+}
+
+// cloning, updating and resolving work without the git command
+func TestGoGitCloneAndUpdate(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	assertErrNil(t, runGitInDir(false, source, "-c", "user.name=test", "-c", "user.email=test@example.com", "tag", "-a", "-m", "tag", "v1"), "Cannot tag")
+	tagged := gitHead(t, source)
+	defer useBackend(goGitBackend{})()
+
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	target := path.Join(dir, "repo")
+	assertErrNil(t, clone(source, target, "", networkPolicy{}), "Clone failed")
+	assertEq(t, gitHead(t, target), tagged)
+	branch, err := remoteDefaultBranch(target, networkPolicy{offline: true})
+	assertErrNil(t, err, "Default branch not recorded")
+	assertEq(t, branch, "main")
+
+	for _, revision := range []string{"", "main", "v1", tagged} {
+		commit, ok, err := remoteCommit(target, source, revision, networkPolicy{})
+		assertErrNil(t, err, "Cannot ask remote")
+		assertEq(t, ok, true)
+		assertEq(t, commit, tagged)
+	}
+
+	// a branch is fast-forwarded, a tag is checked out
+	newCommit := makeTemporaryCommit(t, source)
+	assertErrNil(t, update(target, source, "main", networkPolicy{}), "Update failed")
+	assertEq(t, gitHead(t, target), newCommit)
+	assertErrNil(t, update(target, source, "v1", networkPolicy{}), "Update to tag failed")
+	assertEq(t, gitHead(t, target), tagged)
+
+	// a renamed default branch is followed
+	assertErrNil(t, runGitInDir(false, source, "branch", "-m", "main", "trunk"), "Cannot rename branch")
+	newCommit = makeTemporaryCommit(t, source)
+	assertErrNil(t, update(target, source, "", networkPolicy{}), "Update failed")
+	assertEq(t, gitHead(t, target), newCommit)
+	branch, err = currentBackend.SymbolicRef(target, "HEAD")
+	assertErrNil(t, err, "Cannot get current branch")
+	assertEq(t, branch, "trunk")
+}
+
+// the go-git backend sees the worktree like git does
+func TestGoGitStatusAndDiff(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	head := gitHead(t, target)
+	files, err := ioutil.ReadDir(target)
+	assertErrNil(t, err, "Cannot read clone")
+	var tracked string
+	for _, file := range files {
+		if file.Name() != ".git" {
+			tracked = file.Name()
+		}
+	}
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, tracked), []byte("changed\n"), 0644), "Cannot change file")
+	assertErrNil(t, os.Mkdir(path.Join(target, "dir"), 0755), "Cannot create directory")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "dir", "new"), []byte("new\n"), 0644), "Cannot create file")
+
+	want, err := execBackend{}.Status(target)
+	assertErrNil(t, err, "Cannot get status with git")
+	found, err := goGitBackend{}.Status(target)
+	assertErrNil(t, err, "Cannot get status with go-git")
+	assertEq(t, fmt.Sprint(found), fmt.Sprint(want))
+
+	var diff strings.Builder
+	assertErrNil(t, goGitBackend{}.Diff(target, head, &diff), "Cannot diff")
+	for _, line := range []string{"+++ b/" + tracked, "+changed", "+++ b/dir/new", "+new"} {
+		if !strings.Contains(diff.String(), line+"\n") {
+			t.Fatalf("Diff lacks '%s':\n%s", line, diff.String())
+		}
+	}
+
+	// an update with uncommitted changes fails instead of discarding them
+	makeTemporaryCommit(t, source)
+	defer useBackend(goGitBackend{})()
+	assertEq(t, update(target, source, "main", networkPolicy{}) != nil, true)
+	assertEq(t, gitHead(t, target), head)
+}
+
+func TestGoGitAheadBehind(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	makeTemporaryCommit(t, target)
+	makeTemporaryCommit(t, source)
+	second := makeTemporaryCommit(t, source)
+	assertErrNil(t, runGitInDir(false, target, "fetch", "--quiet", "origin"), "Cannot fetch")
+
+	ahead, behind, err := goGitBackend{}.AheadBehind(target, "origin/main")
+	assertErrNil(t, err, "Cannot count commits")
+	assertEq(t, ahead, 1)
+	assertEq(t, behind, 2)
+	want, err := execBackend{}.Log(target, second)
+	assertErrNil(t, err, "Cannot log with git")
+	found, err := goGitBackend{}.Log(target, second)
+	assertErrNil(t, err, "Cannot log with go-git")
+	assertEq(t, len(found), 2)
+	assertEq(t, found[0][8:], want[0][strings.Index(want[0], " ")+1:])
+}
+
+// without the git command, local repositories can be cloned and backups
+// made and restored, in a format git understands
+func TestGoGitBackup(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	target := path.Join(dir, "repo")
+	defer useBackend(goGitBackend{})()
+	savedPath := os.Getenv("PATH")
+	os.Setenv("PATH", "")
+	err = clone(source, target, "", networkPolicy{})
+	os.Setenv("PATH", savedPath)
+	assertErrNil(t, err, "Clone failed")
+
+	tracked, err := gitOutput(target, "ls-files")
+	assertErrNil(t, err, "Cannot list tracked files")
+	trackedFile := path.Join(target, strings.Fields(tracked)[0])
+	assertErrNil(t, ioutil.WriteFile(trackedFile, []byte("modified\n"), 0644), "Cannot modify file")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "staged"), []byte("staged\n"), 0644), "Cannot write file")
+	assertErrNil(t, runGitInDir(false, target, "add", "staged"), "Cannot stage file")
+	assertErrNil(t, os.Mkdir(path.Join(target, "dir"), 0755), "Cannot create directory")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "dir", "untracked"), []byte("new\n"), 0644), "Cannot write file")
+	want, err := execBackend{}.Status(target)
+	assertErrNil(t, err, "Cannot get status with git")
+
+	os.Setenv("PATH", "")
+	_, err = backup(target)
+	assertErrNil(t, err, "Backup failed")
+	clean, err := isClean(target)
+	assertErrNil(t, err, "Cannot check worktree")
+	assertEq(t, clean, true)
+	_, err = restoreBackup(target)
+	os.Setenv("PATH", savedPath)
+	assertErrNil(t, err, "Restore failed")
+	found, err := execBackend{}.Status(target)
+	assertErrNil(t, err, "Cannot get status with git")
+	assertEq(t, fmt.Sprint(found), fmt.Sprint(want))
+	content, err := ioutil.ReadFile(path.Join(target, "dir", "untracked"))
+	assertErrNil(t, err, "Cannot read untracked file")
+	assertEq(t, string(content), "new\n")
+
+	// git restores what go-git saved
+	_, err = backup(target)
+	assertErrNil(t, err, "Backup failed")
+	currentBackend = execBackend{}
+	_, err = restoreBackup(target)
+	assertErrNil(t, err, "Restore with git failed")
+	found, err = execBackend{}.Status(target)
+	assertErrNil(t, err, "Cannot get status with git")
+	assertEq(t, fmt.Sprint(found), fmt.Sprint(want))
+}
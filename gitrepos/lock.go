@@ -0,0 +1,173 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// defaultLockTimeout is how long we wait for a lock if
+// HOLO_GIT_REPOS_LOCK_TIMEOUT is not set.
+const defaultLockTimeout = 30 * time.Second
+
+// lockPollInterval is how often we retry to get a lock while waiting.
+const lockPollInterval = 100 * time.Millisecond
+
+// fileLock is an advisory lock (see flock(2)) on a lock file.
+type fileLock struct {
+	file *os.File
+}
+
+// lockTimeout returns how long to wait for a lock, as configured in
+// HOLO_GIT_REPOS_LOCK_TIMEOUT (e.g. "10s"; "0" means not to wait).
+func lockTimeout() (time.Duration, error) {
+	value := os.Getenv("HOLO_GIT_REPOS_LOCK_TIMEOUT")
+	if value == "" {
+		return defaultLockTimeout, nil
+	}
+	if value == "0" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid HOLO_GIT_REPOS_LOCK_TIMEOUT: %w", err)
+	}
+	return timeout, nil
+}
+
+// lockDir returns the directory the lock files are kept in. It is below
+// HOLO_STATE_DIR or, if that is not set, a directory of the current user
+// in the temporary directory, which other users can neither use nor
+// take over.
+func lockDir() (string, error) {
+	if dir := stateDir(); dir != "" {
+		return filepath.Join(dir, "locks"), nil
+	}
+	dir := filepath.Join(os.TempDir(), "holo-git-repos-locks-"+strconv.Itoa(os.Getuid()))
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return "", fmt.Errorf("cannot create lock directory: %w", err)
+	}
+	// it may have been there before, made by someone else
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return "", fmt.Errorf("cannot create lock directory: %w", err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok || int(stat.Uid) != os.Getuid() || info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("lock directory %s is not private to the current user, set HOLO_STATE_DIR or remove it", dir)
+	}
+	return dir, nil
+}
+
+// acquireLock locks the lock file at lockPath exclusively, waiting for
+// the configured timeout (see lockTimeout) if another process holds it.
+// The lock is held until unlock is called or the process exits. What is
+// locked is only used in messages.
+func acquireLock(lockPath string, what string) (*fileLock, error) {
+	timeout, err := lockTimeout()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create lock directory: %w", err)
+	}
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open lock file: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != syscall.EWOULDBLOCK && err != syscall.EINTR {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			file.Close()
+			pid, _ := lockHolder(lockPath)
+			return nil, &LockedError{What: what, PID: pid}
+		}
+		time.Sleep(lockPollInterval)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("cannot lock %s: %w", what, err)
+	}
+
+	// tell others who holds the lock
+	if err := file.Truncate(0); err == nil {
+		file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &fileLock{file}, nil
+}
+
+// lockHolder returns the PID of the process that holds (or last held)
+// the lock file at lockPath.
+func lockHolder(lockPath string) (int, error) {
+	data, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// unlock releases the lock.
+func (l *fileLock) unlock() error {
+	// closing the file releases the flock
+	return l.file.Close()
+}
+
+// lockTarget locks the target path of an entity against concurrent
+// holo-git-repos runs. The lock is keyed on the cleaned path, not on the
+// entity, since two entities could have the same target.
+func lockTarget(path string) (*fileLock, error) {
+	hash := sha256.Sum256([]byte(filepath.Clean(path)))
+	name := "target-" + hex.EncodeToString(hash[:8]) + "-" + filepath.Base(path) + ".lock"
+	dir, err := lockDir()
+	if err != nil {
+		return nil, err
+	}
+	return acquireLock(filepath.Join(dir, name), path)
+}
+
+// withStateLock runs f while holding the global lock for writes to the
+// state directory. It must not be nested, since flock(2) locks of the
+// same process on different file descriptors block each other.
+func withStateLock(f func() error) error {
+	dir, err := lockDir()
+	if err != nil {
+		return err
+	}
+	lock, err := acquireLock(filepath.Join(dir, "state.lock"), "the state directory")
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+	return f()
+}
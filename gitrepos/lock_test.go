@@ -0,0 +1,98 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLockTarget(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	defer os.Unsetenv("HOLO_GIT_REPOS_LOCK_TIMEOUT")
+	os.Setenv("HOLO_GIT_REPOS_LOCK_TIMEOUT", "200ms")
+
+	lock, err := lockTarget("/some/target")
+	assertErrNil(t, err, "Cannot lock target")
+
+	// a second lock waits for the timeout and names the holder
+	start := time.Now()
+	_, err = lockTarget("/some/target/")
+	lockedErr, ok := err.(*LockedError)
+	if !ok {
+		t.Fatalf("Expected *LockedError, got %v", err)
+	}
+	assertEq(t, lockedErr.PID, os.Getpid())
+	assertEq(t, time.Since(start) >= 200*time.Millisecond, true)
+
+	// other targets are not affected
+	other, err := lockTarget("/some/other/target")
+	assertErrNil(t, err, "Cannot lock other target")
+	assertErrNil(t, other.unlock(), "Cannot unlock other target")
+
+	// after unlocking, the target can be locked again
+	assertErrNil(t, lock.unlock(), "Cannot unlock target")
+	lock, err = lockTarget("/some/target")
+	assertErrNil(t, err, "Cannot lock target again")
+	assertErrNil(t, lock.unlock(), "Cannot unlock target")
+}
+
+func TestLockTimeout(t *testing.T) {
+	defer os.Unsetenv("HOLO_GIT_REPOS_LOCK_TIMEOUT")
+
+	os.Unsetenv("HOLO_GIT_REPOS_LOCK_TIMEOUT")
+	timeout, err := lockTimeout()
+	assertErrNil(t, err, "Cannot get default timeout")
+	assertEq(t, timeout, defaultLockTimeout)
+
+	os.Setenv("HOLO_GIT_REPOS_LOCK_TIMEOUT", "0")
+	timeout, err = lockTimeout()
+	assertErrNil(t, err, "Cannot parse timeout")
+	assertEq(t, timeout, time.Duration(0))
+
+	os.Setenv("HOLO_GIT_REPOS_LOCK_TIMEOUT", "soon")
+	_, err = lockTimeout()
+	assertEq(t, err != nil, true)
+}
+
+// without a state directory, every user gets a private lock directory
+func TestLockDirPerUser(t *testing.T) {
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	tmp, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	os.Setenv("TMPDIR", tmp)
+
+	dir, err := lockDir()
+	assertErrNil(t, err, "Cannot get lock directory")
+	assertEq(t, dir, filepath.Join(tmp, "holo-git-repos-locks-"+strconv.Itoa(os.Getuid())))
+	info, err := os.Stat(dir)
+	assertErrNil(t, err, "Lock directory not created")
+	assertEq(t, info.Mode().Perm(), os.FileMode(0700))
+
+	// a directory others can write to is not used
+	assertErrNil(t, os.Chmod(dir, 0777), "Cannot open up lock directory")
+	_, err = lockDir()
+	assertEq(t, err != nil, true)
+}
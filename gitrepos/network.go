@@ -0,0 +1,186 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Defaults for network operations, used if neither the environment nor
+// the entity say otherwise.
+const (
+	defaultNetworkTimeout = 10 * time.Minute
+	defaultNetworkRetries = 2
+)
+
+// retryBackoff is how long we wait before the first retry of a failed
+// network operation. It is doubled for every further retry.
+var retryBackoff = time.Second
+
+// networkPolicy says how long a git command that talks to a remote (i.e.
+// clone, fetch and ls-remote) may take and how often it is retried if it
+// fails. A zero timeout means no timeout. In offline mode, the remote is
+// not contacted at all and only what was fetched before is used.
+type networkPolicy struct {
+	timeout time.Duration
+	retries int
+	offline bool
+}
+
+// parseTimeout parses a timeout setting. It is a duration like '90s' or
+// '5m', or '0' for no timeout.
+func parseTimeout(value string) (time.Duration, error) {
+	if value == "0" {
+		return 0, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if timeout < 0 {
+		return 0, errors.New("timeout must not be negative")
+	}
+	return timeout, nil
+}
+
+// parseRetries parses a retries setting, i.e. a non-negative integer.
+func parseRetries(value string) (int, error) {
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		return 0, fmt.Errorf("'%s' is not a non-negative integer", value)
+	}
+	return retries, nil
+}
+
+// parseOffline parses an offline setting, i.e. a boolean like 'true' or
+// '0'.
+func parseOffline(value string) (bool, error) {
+	offline, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("'%s' is neither true nor false", value)
+	}
+	return offline, nil
+}
+
+// globalNetworkPolicy returns the network policy configured by the
+// environment variables HOLO_GIT_REPOS_NETWORK_TIMEOUT,
+// HOLO_GIT_REPOS_NETWORK_RETRIES and HOLO_GIT_REPOS_OFFLINE.
+func globalNetworkPolicy() (networkPolicy, error) {
+	policy := networkPolicy{defaultNetworkTimeout, defaultNetworkRetries, false}
+	var err error
+	if value := os.Getenv("HOLO_GIT_REPOS_NETWORK_TIMEOUT"); value != "" {
+		if policy.timeout, err = parseTimeout(value); err != nil {
+			return policy, fmt.Errorf("invalid HOLO_GIT_REPOS_NETWORK_TIMEOUT: %w", err)
+		}
+	}
+	if value := os.Getenv("HOLO_GIT_REPOS_NETWORK_RETRIES"); value != "" {
+		if policy.retries, err = parseRetries(value); err != nil {
+			return policy, fmt.Errorf("invalid HOLO_GIT_REPOS_NETWORK_RETRIES: %w", err)
+		}
+	}
+	if value := os.Getenv("HOLO_GIT_REPOS_OFFLINE"); value != "" {
+		if policy.offline, err = parseOffline(value); err != nil {
+			return policy, fmt.Errorf("invalid HOLO_GIT_REPOS_OFFLINE: %w", err)
+		}
+	}
+	return policy, nil
+}
+
+// networkPolicy returns the network policy for the entity: the global
+// one, overridden by the entity's timeout, retries and offline keys.
+func (e Entity) networkPolicy() (networkPolicy, error) {
+	policy, err := globalNetworkPolicy()
+	if err != nil {
+		return policy, err
+	}
+	// the values have been checked when parsing the entity file
+	if e.Timeout != "" {
+		policy.timeout, _ = parseTimeout(e.Timeout)
+	}
+	if e.Retries != "" {
+		policy.retries, _ = parseRetries(e.Retries)
+	}
+	if e.Offline != "" {
+		policy.offline, _ = parseOffline(e.Offline)
+	}
+	return policy, nil
+}
+
+// retryNetwork calls attempt until it succeeds, at most 1+policy.retries
+// times, waiting with exponential backoff in between. Every attempt gets
+// a context that expires after policy.timeout. Nothing is retried once
+// gitContext is cancelled, and neither is a failure that a retry cannot
+// fix, i.e. anything but a timeout or an unreachable host (see
+// classifyGitError). If the last attempt fails with a *GitError, the
+// number of attempts is recorded in it.
+// Only use this for operations that are safe to repeat, i.e. that talk
+// to a remote and don't change anything but what they fetch.
+func retryNetwork(policy networkPolicy, attempt func(ctx context.Context) error) error {
+	backoff := retryBackoff
+	for n := 1; ; n++ {
+		ctx, cancel := gitContext, context.CancelFunc(func() {})
+		if policy.timeout > 0 {
+			ctx, cancel = context.WithTimeout(gitContext, policy.timeout)
+		}
+		err := attempt(ctx)
+		timedOut := ctx.Err() == context.DeadlineExceeded && gitContext.Err() == nil
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		var gitErr *GitError
+		isGitErr := errors.As(err, &gitErr)
+		if timedOut && isGitErr {
+			gitErr.Err = &TimeoutError{policy.timeout}
+		}
+		transient := isGitErr && classifyGitError(gitErr) == FailureUnreachable
+		if n > policy.retries || gitContext.Err() != nil || !transient {
+			if isGitErr {
+				gitErr.Attempts = n
+			}
+			return err
+		}
+
+		Warn(fmt.Sprintf("%s (attempt %d of %d), retrying in %s", err, n, policy.retries+1, backoff))
+		select {
+		case <-time.After(backoff):
+		case <-gitContext.Done():
+			if isGitErr {
+				gitErr.Attempts = n
+			}
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// isNetworkError reports whether err is a git command failing to reach a
+// remote, i.e. timing out or not getting through to the host. When
+// applying, such a failure makes us go on in offline mode. Other failures,
+// like a wrong URL or failed authentication, are not hidden that way.
+func isNetworkError(err error) bool {
+	return Classify(err) == FailureUnreachable
+}
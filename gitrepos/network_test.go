@@ -0,0 +1,231 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNetworkPolicy(t *testing.T) {
+	os.Unsetenv("HOLO_GIT_REPOS_NETWORK_TIMEOUT")
+	os.Unsetenv("HOLO_GIT_REPOS_NETWORK_RETRIES")
+	policy, err := Entity{}.networkPolicy()
+	assertErrNil(t, err, "Cannot get default policy")
+	assertEq(t, policy, networkPolicy{defaultNetworkTimeout, defaultNetworkRetries, false})
+
+	os.Setenv("HOLO_GIT_REPOS_NETWORK_TIMEOUT", "0")
+	os.Setenv("HOLO_GIT_REPOS_NETWORK_RETRIES", "5")
+	defer os.Unsetenv("HOLO_GIT_REPOS_NETWORK_TIMEOUT")
+	defer os.Unsetenv("HOLO_GIT_REPOS_NETWORK_RETRIES")
+	policy, err = Entity{}.networkPolicy()
+	assertErrNil(t, err, "Cannot get global policy")
+	assertEq(t, policy, networkPolicy{0, 5, false})
+
+	// the entity overrides the environment
+	policy, err = Entity{Timeout: "30s", Retries: "0"}.networkPolicy()
+	assertErrNil(t, err, "Cannot get entity policy")
+	assertEq(t, policy, networkPolicy{30 * time.Second, 0, false})
+
+	os.Setenv("HOLO_GIT_REPOS_NETWORK_RETRIES", "-1")
+	_, err = Entity{}.networkPolicy()
+	assertEq(t, err != nil, true)
+}
+
+func TestEntityNetworkKeys(t *testing.T) {
+	content := "url=a\npath=/b\ntimeout=soon\nretries=many\noffline=maybe\n"
+	_, diags := ParseEntityFile("test", strings.NewReader(content))
+	assertEq(t, len(diags), 3)
+	assertEq(t, diags[0].String(), "test:3: invalid value for key 'timeout': time: invalid duration \"soon\"")
+	assertEq(t, diags[1].String(), "test:4: invalid value for key 'retries': 'many' is not a non-negative integer")
+	assertEq(t, diags[2].String(), "test:5: invalid value for key 'offline': 'maybe' is neither true nor false")
+
+	e, diags := ParseEntityFile("test", strings.NewReader("url=a\npath=/b\ntimeout=2m\nretries=3\n"))
+	assertEq(t, len(diags), 0)
+	assertEq(t, e.Timeout, "2m")
+	assertEq(t, e.Retries, "3")
+}
+
+func TestRetryNetwork(t *testing.T) {
+	defer func(saved time.Duration) { retryBackoff = saved }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	unreachable := "fatal: unable to access 'https://example.org/': Could not resolve host: example.org\n"
+
+	// failures are retried and the attempts are reported
+	attempts := 0
+	err := retryNetwork(networkPolicy{0, 2, false}, func(ctx context.Context) error {
+		attempts++
+		return &GitError{Args: []string{"fetch"}, Err: os.ErrNotExist, Stderr: unreachable}
+	})
+	assertEq(t, attempts, 3)
+	assertEq(t, err.Error(), "git fetch failed after 3 attempts: host unreachable (file does not exist)")
+
+	// success ends the retries
+	attempts = 0
+	err = retryNetwork(networkPolicy{0, 2, false}, func(ctx context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return &GitError{Args: []string{"fetch"}, Err: os.ErrNotExist, Stderr: unreachable}
+		}
+		return nil
+	})
+	assertErrNil(t, err, "Retry did not succeed")
+	assertEq(t, attempts, 2)
+
+	// failures that a retry cannot fix are not retried
+	for _, stderr := range []string{
+		"fatal: Authentication failed for 'https://example.org/'\n",
+		"remote: Repository not found.\nfatal: repository 'https://example.org/' not found\n",
+		"",
+	} {
+		attempts = 0
+		err = retryNetwork(networkPolicy{0, 2, false}, func(ctx context.Context) error {
+			attempts++
+			return &GitError{Args: []string{"fetch"}, Err: os.ErrNotExist, Stderr: stderr}
+		})
+		assertEq(t, err != nil, true)
+		assertEq(t, attempts, 1)
+	}
+
+	// hanging commands time out
+	start := time.Now()
+	err = retryNetwork(networkPolicy{100 * time.Millisecond, 0, false}, func(ctx context.Context) error {
+		if err := runCmd(ctx, exec.Command("sleep", "30")); err != nil {
+			return &GitError{Args: []string{"ls-remote"}, Err: err}
+		}
+		return nil
+	})
+	assertEq(t, time.Since(start) < killGracePeriod, true)
+	assertEq(t, err.Error(), "git ls-remote failed: host unreachable (timed out after 100ms)")
+}
+
+func TestCloneRetries(t *testing.T) {
+	defer func(saved time.Duration) { retryBackoff = saved }(retryBackoff)
+	retryBackoff = time.Millisecond
+
+	// nothing listens on port 1
+	target := filepath.Join(t.TempDir(), "target")
+	err := clone("http://127.0.0.1:1/repo", target, "", networkPolicy{0, 1, false})
+	assertEq(t, err != nil, true)
+	assertEq(t, strings.Contains(err.Error(), "failed after 2 attempts"), true)
+	leftovers, _ := filepath.Glob(stagingPattern(target))
+	assertEq(t, len(leftovers), 0)
+
+	// a missing repository does not show up by retrying
+	err = clone(filepath.Join(t.TempDir(), "nonexistent"), target, "", networkPolicy{0, 1, false})
+	assertEq(t, err != nil, true)
+	assertEq(t, strings.Contains(err.Error(), "attempts"), false)
+}
+
+func TestUpdateOffline(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	assertErrNil(t, runGitInDir(false, source, "tag", "v1"), "Cannot tag")
+	tagged := gitHead(t, source)
+	target := cloneTemporary(t, source)
+	makeTemporaryCommit(t, source)
+	os.RemoveAll(source)
+
+	offline := networkPolicy{offline: true}
+	assertErrNil(t, update(target, source, "v1", offline), "Offline update failed")
+	assertEq(t, gitHead(t, target), tagged)
+	assertErrNil(t, update(target, source, "", offline), "Offline update to default branch failed")
+	assertEq(t, gitHead(t, target), tagged)
+	assertEq(t, update(target, source, "v2", offline) != nil, true)
+
+	commit, ok, err := remoteCommit(target, source, "v1", offline)
+	assertErrNil(t, err, "Cannot resolve offline")
+	assertEq(t, ok, true)
+	assertEq(t, commit, tagged)
+	assertEq(t, clone(source, target+"2", "", offline) != nil, true)
+}
+
+// Apply: remote is unreachable
+// => check out the revision if it is available locally
+func TestApplyOffline(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	os.Setenv("HOLO_GIT_REPOS_NETWORK_RETRIES", "0")
+	defer os.Unsetenv("HOLO_GIT_REPOS_NETWORK_RETRIES")
+	source := makeTemporaryGitRepo(t)
+	assertErrNil(t, runGitInDir(false, source, "tag", "v1"), "Cannot tag")
+	tagged := gitHead(t, source)
+	makeTemporaryCommit(t, source)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+
+	// nothing listens on port 1
+	entityFile := filepath.Join(os.Getenv("HOLO_RESOURCE_DIR"), entityId)
+	setRevision := func(revision string) {
+		content := "url=http://127.0.0.1:1/repo\npath=" + target + "\nrevision=" + revision + "\n"
+		assertErrNil(t, ioutil.WriteFile(entityFile, []byte(content), 0644), "Cannot write entity file")
+	}
+
+	setRevision("v1")
+	assertErrNil(t, Apply(entityId, Options{}), "Offline apply failed")
+	assertEq(t, gitHead(t, target), tagged)
+
+	setRevision("v2")
+	assertEq(t, Apply(entityId, Options{}) != nil, true)
+	assertEq(t, gitHead(t, target), tagged)
+}
+
+// Apply: remote is reachable, but the repository is not there
+// => fail instead of going on offline
+func TestApplyNotFoundNotOffline(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	applied := gitHead(t, target)
+
+	makeTemporaryCommit(t, source)
+	assertErrNil(t, os.Rename(source, source+".moved"), "Cannot move source")
+	defer os.Rename(source+".moved", source)
+	err := Apply(entityId, Options{})
+	assertEq(t, err != nil, true)
+	assertEq(t, Classify(err), FailureNotFound)
+	assertEq(t, gitHead(t, target), applied)
+}
+
+// only an unreachable remote makes applying go on offline
+func TestIsNetworkError(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected bool
+	}{
+		{&GitError{Err: os.ErrNotExist, Stderr: "fatal: unable to access 'https://example.org/': Could not resolve host: example.org\n"}, true},
+		{&GitError{Err: &TimeoutError{time.Second}}, true},
+		{&GitError{Err: os.ErrNotExist, Stderr: "fatal: Authentication failed for 'https://example.org/'\n"}, false},
+		{&GitError{Err: os.ErrNotExist, Stderr: "fatal: repository 'https://example.org/' not found\n"}, false},
+		{&GitError{Err: os.ErrNotExist, Attempts: 3}, false},
+		{os.ErrNotExist, false},
+	}
+	for _, c := range cases {
+		assertEq(t, isNetworkError(c.err), c.expected)
+	}
+}
@@ -0,0 +1,423 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Options configure the operations. The zero value configures them
+// like holo does, through the environment.
+type Options struct {
+	// ResourceDir is the directory with the entity files. If it is
+	// emptystring, $HOLO_RESOURCE_DIR is used.
+	ResourceDir string
+	// Force makes Apply overwrite targets that were changed since the
+	// last apply, like 'holo apply --force'.
+	Force bool
+	// Format is the output format of Scan.
+	Format Format
+}
+
+// Format is an output format of Scan.
+type Format int
+
+const (
+	FormatHolo      Format = iota // the line-based format holo reads
+	FormatJSON                    // a JSON array of objects
+	FormatJSONLines               // one JSON object per line
+)
+
+// scanEntry is what Scan reports about an entity in the JSON formats:
+// the settings from the entity file and the state of its repository
+// (see getStatus). Optional settings that are not set are emptystring.
+type scanEntry struct {
+	RepoStatus
+	Source      string        `json:"source"`
+	Timeout     string        `json:"timeout"`
+	Retries     string        `json:"retries"`
+	Offline     string        `json:"offline"`
+	LastApplied *appliedState `json:"lastApplied,omitempty"`
+}
+
+// Scan executes the 'holo scan' operation. It scans the resource
+// directory for entities that can be provisioned and prints them to w in
+// holo's format, or in the JSON format given by opts.Format. Only the
+// JSON formats include the state of the repositories.
+// Broken entity files are reported on stderr and skipped, so that the
+// valid entities can still be provisioned. The error about them is only
+// returned after all valid entities have been printed.
+func Scan(w io.Writer, opts Options) error {
+	entities, err := ParseEntities(opts)
+	if fatalErr := ReportBrokenEntities(err); fatalErr != nil {
+		return fatalErr
+	}
+	if opts.Format != FormatHolo {
+		if jsonErr := printScanJSON(w, entities, opts.Format); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+
+	for _, e := range entities {
+		fmt.Fprintln(w, "ENTITY: git-repo:"+e.ID)
+		fmt.Fprintln(w, "SOURCE: "+e.File)
+		fmt.Fprintln(w, "url: "+e.URL)
+		fmt.Fprintln(w, "revision: "+e.Revision)
+		fmt.Fprintln(w, "clone into: "+e.Path)
+
+		// state is only informational here, so a broken state file is no reason to fail
+		if state, _ := loadState(e.ID); state != nil {
+			fmt.Fprintln(w, "last applied: "+state.Commit+" at "+state.AppliedAt.Format(time.RFC3339))
+		}
+	}
+	return err
+}
+
+// printScanJSON prints the entities with the state of their
+// repositories to w in the given JSON format.
+func printScanJSON(w io.Writer, entities []Entity, format Format) error {
+	entries := make([]scanEntry, len(entities))
+	for i, e := range entities {
+		entries[i] = scanEntry{getStatus(e), e.File, e.Timeout, e.Retries, e.Offline, nil}
+		// like the state, this is only informational
+		entries[i].LastApplied, _ = loadState(e.ID)
+	}
+
+	encoder := json.NewEncoder(w)
+	if format == FormatJSONLines {
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// applyDecision is what Apply has to do with the target of an entity.
+type applyDecision int
+
+const (
+	decideClone      applyDecision = iota // the target does not exist
+	decideNotChanged                      // the target already is in the desired state
+	decideUpdate                          // the target is a git repo
+	decideReplace                         // the target is not a git repo
+)
+
+func (d applyDecision) String() string {
+	return [...]string{"clone", "not changed", "update", "replace"}[d]
+}
+
+// decideApply inspects the target of entity e and decides what applying
+// it has to do. If force is false and the target was changed since the
+// last apply (see localChanges), a *TargetConflictError is returned.
+// Nothing is changed, so plan uses this as well.
+func decideApply(e Entity, force bool) (applyDecision, error) {
+
+	// check if directory already exists
+	_, err := os.Stat(e.Path)
+	if os.IsNotExist(err) {
+		return decideClone, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("cannot stat path %s: %w", e.Path, err)
+	}
+
+	// check if it's even a repo
+	isRepo, err := isGitRepo(e.Path)
+	if err != nil {
+		return 0, err
+	}
+
+	// check if there is nothing to do
+	if isRepo {
+		upToDate, err := isUpToDate(e)
+		if err != nil {
+			return 0, err
+		}
+		if upToDate {
+			return decideNotChanged, nil
+		}
+	}
+
+	// if we're not forced, let holo know unless the target is
+	// exactly what we left there last time
+	if !force {
+		reason := "is not a git repository"
+		if isRepo {
+			if reason, err = localChanges(e); err != nil {
+				return 0, err
+			}
+		}
+		if reason != "" {
+			return 0, &TargetConflictError{Path: e.Path, IsRepo: isRepo, Reason: reason}
+		}
+	}
+
+	if isRepo {
+		return decideUpdate, nil
+	}
+	return decideReplace, nil
+}
+
+// Apply executes the 'holo apply' operation. It applies the entity with ID entityId.
+// It clones the repository and, if revision is not emptystring, checks out that revision.
+// If the target already exists, the behavior depends on a few things (see decideApply):
+//   - If the target already is in the desired state (see isUpToDate),
+//     return ErrNotChanged without touching it
+//   - If opts.Force is false and the target was changed since the last
+//     apply (see localChanges), return a *TargetConflictError, so that
+//     the caller can return control to holo with the corresponding
+//     message
+//   - If the target is a git repo, fetch from the url and check out the
+//     revision (see update). When forced, HEAD and uncommitted changes
+//     are saved first (see backup). If the remote cannot be reached, the
+//     revision is checked out from what was fetched before (offline mode,
+//     see networkPolicy).
+//   - If the target is not a git repo or the update failed, replace it
+//     by a fresh clone and move it into quarantine (see quarantine).
+//     This is the last resort.
+//
+// Clones are prepared next to the target and only moved into place when
+// they are complete (see stageClone).
+func Apply(entityId string, opts Options) error {
+
+	e, err := ParseEntity(entityId, opts)
+	if err != nil {
+		return err
+	}
+	url, path, revision, force := e.URL, e.Path, e.Revision, opts.Force
+	policy, err := e.networkPolicy()
+	if err != nil {
+		return err
+	}
+
+	// another run must not change the target between our decision and
+	// carrying it out
+	lock, err := lockTarget(path)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	decision, err := decideApply(e, force)
+	if err != nil {
+		return err
+	}
+	Logf(LogInfo, "applying %s to %s: %s", entityId, path, decision)
+
+	switch decision {
+	case decideNotChanged:
+		if err := recordAppliedIfChanged(e); err != nil {
+			return err
+		}
+		return ErrNotChanged
+
+	case decideUpdate:
+		snapshot, err := snapshotForRollback(path)
+		if err != nil {
+			return err
+		}
+
+		// when forced, the user may have made changes, so keep them
+		if force {
+			timestamp, err := backup(path)
+			if err != nil {
+				return err
+			}
+			snapshot.backup = timestamp
+		}
+
+		// try to update the repo in place first, using what we have if
+		// the remote cannot be reached
+		err = update(path, url, revision, policy)
+		if err != nil && InterruptedBy() == nil && isNetworkError(err) {
+			Warn("cannot reach", url+", applying offline:", err)
+			policy.offline = true
+			err = update(path, url, revision, policy)
+		}
+		if err != nil {
+			// don't leave a half-updated repo behind, e.g. with origin
+			// pointing to a mistyped url
+			if rollbackErr := rollbackUpdate(path, snapshot); rollbackErr != nil {
+				Warn("cannot roll back failed update of", path+":", rollbackErr)
+			}
+		}
+		if err != nil && (InterruptedBy() != nil || !force) {
+			// without force, we must not throw the repository away
+			return err
+		}
+		if err != nil {
+			// the changes were put back into the worktree (or, if that
+			// failed, are still in the backup refs), so they go into
+			// quarantine with it, where undo cannot find them
+			Warn("cannot update", path, "in place, recloning it:", err)
+			decision = decideReplace
+		} else if snapshot.backup != "" {
+			Warn("saved previous state of", path, "as", backupRefPrefix+snapshot.backup+", use 'holo-git-repos undo "+entityId+"' to restore it")
+		}
+	}
+
+	// the clone is cleaned up by stageClone when we are interrupted, but we
+	// must not go on to replace the target
+	if err := gitContext.Err(); err != nil {
+		return err
+	}
+
+	// if it's not a repo or the update failed, replace it by a fresh
+	// clone. The clone is completed before the old target is moved out
+	// of the way, so that a failing clone leaves the old target alone.
+	if decision == decideReplace {
+		if err := checkSafeToDelete(path); err != nil {
+			return err
+		}
+		staging, err := stageClone(url, path, revision, policy)
+		if err != nil {
+			return fmt.Errorf("cannot clone repository %s into %s with revision %s: %w", url, path, revision, err)
+		}
+		entry, err := quarantine(entityId, path)
+		if err != nil {
+			os.RemoveAll(staging)
+			return err
+		}
+		if err := installStaged(staging, path); err != nil {
+			// put the old target back, so that path is not left empty
+			if restoreErr := unquarantine(entry); restoreErr != nil {
+				Warn("cannot move", path, "back out of quarantine, use 'holo-git-repos restore "+entry.ID+"' to restore it:", restoreErr)
+			}
+			return err
+		}
+		Warn("moved", path, "into quarantine, use 'holo-git-repos restore "+entry.ID+"' to restore it")
+	}
+
+	// if the target does not exist, clone it
+	if decision == decideClone {
+		if err := clone(url, path, revision, policy); err != nil {
+			return fmt.Errorf("cannot clone repository %s into %s with revision %s: %w", url, path, revision, err)
+		}
+	}
+
+	// remember what we provisioned
+	return recordApplied(e)
+}
+
+// printSettingsDiff prints a unified diff between the desired and the
+// actual settings of the repository at path. It is used for differences
+// that git cannot show, like a missing target.
+func printSettingsDiff(w io.Writer, path string, desired []string, actual []string) {
+	actualName, actualStart := path+" (actual)", 1
+	if actual == nil {
+		actualName, actualStart = "/dev/null", 0
+	}
+	fmt.Fprintln(w, "--- "+path+" (desired)")
+	fmt.Fprintln(w, "+++ "+actualName)
+	fmt.Fprintf(w, "@@ -1,%d +%d,%d @@\n", len(desired), actualStart, len(actual))
+	for _, line := range desired {
+		fmt.Fprintln(w, "-"+line)
+	}
+	for _, line := range actual {
+		fmt.Fprintln(w, "+"+line)
+	}
+}
+
+// desiredCommit returns the commit the repository of entity e should be
+// at, without contacting the remote. If the last apply was for the same
+// url and revision, that is the commit it checked out. Otherwise, the
+// revision is resolved from what is available locally.
+func desiredCommit(e Entity) (string, error) {
+	state, err := loadState(e.ID)
+	if err != nil {
+		return "", err
+	}
+	if state != nil && state.Path == e.Path && state.URL == e.URL && state.Revision == e.Revision {
+		return state.Commit, nil
+	}
+	return resolveLocal(e.Path, e.Revision)
+}
+
+// Diff executes the 'holo diff' operation.
+// It prints to w a unified diff between the desired state of the entity with
+// ID entityId and the actual state of its repository. The diff covers
+//   - a missing target or a target that is not a git repository,
+//   - an origin that differs from the url,
+//   - committed, staged and unstaged changes relative to the desired
+//     commit (see desiredCommit), and
+//   - untracked files.
+func Diff(entityId string, w io.Writer, opts Options) error {
+
+	e, err := ParseEntity(entityId, opts)
+	if err != nil {
+		return err
+	}
+	desired := []string{"url=" + e.URL, "revision=" + e.Revision}
+
+	// target that would be created
+	_, err = os.Stat(e.Path)
+	if os.IsNotExist(err) {
+		fmt.Fprintln(w, "# "+e.Path+" does not exist and would be cloned")
+		printSettingsDiff(w, e.Path, desired, nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot stat path %s: %w", e.Path, err)
+	}
+
+	// target that would be replaced
+	isRepo, err := isGitRepo(e.Path)
+	if err != nil {
+		return err
+	}
+	if !isRepo {
+		printSettingsDiff(w, e.Path, desired, []string{"(not a git repository)"})
+		return nil
+	}
+	repo, err := filepath.EvalSymlinks(e.Path)
+	if err != nil {
+		return fmt.Errorf("possibly dead symlink in path %s: %w", e.Path, err)
+	}
+
+	// different origin
+	origin, _ := originURL(repo)
+	if origin != e.URL {
+		printSettingsDiff(w, e.Path, []string{"url=" + e.URL}, []string{"url=" + origin})
+	}
+
+	// commits, staged and unstaged changes and untracked files
+	commit, err := desiredCommit(e)
+	if err != nil {
+		return err
+	}
+	return currentBackend.Diff(repo, commit, w)
+}
+
+// Validate parses the entity with ID entityId and returns every problem
+// it finds, including warnings, which the holo operations only print.
+func Validate(entityId string, opts Options) ([]Diagnostic, error) {
+	_, diags, err := loadEntity(entityId, opts)
+	return diags, err
+}
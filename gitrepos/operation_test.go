@@ -0,0 +1,296 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+
+	// create temporary directory with entity file
+	tempDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	testUrl := "TestScan_testUrl"
+	testPath := "/TestScan_testPath"
+	testRevision := "TestScan_testRevision"
+	entityFilePath1 := makeTemporaryEntityFile(t, tempDir, testUrl, testPath, testRevision)
+	entityFileName1 := path.Base(entityFilePath1)
+	//entityFileName2 := makeTemporaryEntityFile(t, tempDir, testUrl, testPath, testRevision)
+
+	// call function and check output
+	expected := "ENTITY: git-repo:" + entityFileName1
+	expected += "\nSOURCE: " + entityFilePath1
+	expected += "\nurl: " + testUrl
+	expected += "\nrevision: " + testRevision
+	expected += "\nclone into: " + testPath
+	expected += "\n"
+	os.Setenv("HOLO_RESOURCE_DIR", tempDir)
+	scanOutput := getFunctionOutput(func() { assertErrNil(t, Scan(os.Stdout, Options{}), "Scan failed") })
+	assertEq(t, scanOutput, expected)
+}
+
+// Apply: Target does not exist
+// => clone, checkout
+// basically like first-time provisioning
+func TestApplyNotexistentTarget(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	first := gitHead(t, source)
+	makeTemporaryCommit(t, source)
+	entityId, target := makeTemporaryApplyEnv(t, source, first)
+
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	assertEq(t, gitHead(t, target), first)
+}
+
+// Apply: Target exists and not forced
+// => "needs force"
+func TestApplyExistNoForce(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+
+	err := Apply(entityId, Options{})
+	conflict, ok := err.(*TargetConflictError)
+	if !ok {
+		t.Fatalf("Expected *TargetConflictError, got %v", err)
+	}
+	assertEq(t, conflict.Path, target)
+	assertEq(t, conflict.IsRepo, false)
+}
+
+// Apply: Target exists and forced and target is repo
+// => fetch, checkout
+func TestApplyForceRepo(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	first := gitHead(t, source)
+	second := makeTemporaryCommit(t, source)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	assertEq(t, gitHead(t, target), second)
+
+	// add entity with the other revision for the same target
+	resourceDir := os.Getenv("HOLO_RESOURCE_DIR")
+	entityId = path.Base(makeTemporaryEntityFile(t, resourceDir, source, target, first))
+
+	assertErrNil(t, Apply(entityId, Options{Force: true}), "Force-apply failed")
+	assertEq(t, gitHead(t, target), first)
+}
+
+// Apply: Target exists and forced and target is repo with a different origin
+// => fetch from new url, checkout
+func TestApplyForceRepoOtherOrigin(t *testing.T) {
+	other := makeTemporaryGitRepo(t)
+	source := makeTemporaryGitRepo(t)
+	revision := gitHead(t, source)
+	entityId, target := makeTemporaryApplyEnv(t, source, revision)
+	assertErrNil(t, runGit(false, "clone", "-q", other, target), "Cannot clone other repo")
+
+	assertErrNil(t, Apply(entityId, Options{Force: true}), "Force-apply failed")
+	assertEq(t, gitHead(t, target), revision)
+}
+
+// Apply: Target exists and is repo and revision non-existent
+// => unknown revision, target is left as it was
+func TestApplyRepoNonexistentRevision(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	applied := gitHead(t, target)
+	makeTemporaryCommit(t, source)
+
+	entityFile := path.Join(os.Getenv("HOLO_RESOURCE_DIR"), entityId)
+	content := "url=" + source + "\npath=" + target + "\nrevision=nonexistent-revision\n"
+	assertErrNil(t, ioutil.WriteFile(entityFile, []byte(content), 0644), "Cannot write entity file")
+	err := Apply(entityId, Options{})
+	var revisionErr *UnknownRevisionError
+	assertEq(t, errors.As(err, &revisionErr), true)
+	assertEq(t, revisionErr.Revision, "nonexistent-revision")
+	assertEq(t, gitHead(t, target), applied)
+	branch, err := gitOutput(target, "symbolic-ref", "--short", "HEAD")
+	assertErrNil(t, err, "HEAD is not on a branch")
+	assertEq(t, branch, "main")
+}
+
+// Apply: Target exists and forced and target is no repo
+// => quarantine, clone, checkout
+func TestApplyForceNoRepo(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+
+	assertErrNil(t, Apply(entityId, Options{Force: true}), "Force-apply failed")
+	isRepo, err := isGitRepo(target)
+	assertErrNil(t, err, "Cannot check target")
+	assertEq(t, isRepo, true)
+	assertEq(t, gitHead(t, target), gitHead(t, source))
+}
+
+// Apply: Target is a regular file
+// => conflict, and when forced, the file is quarantined
+func TestApplyForceFile(t *testing.T) {
+	stateDir := setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, ioutil.WriteFile(target, []byte("precious\n"), 0644), "Cannot create target")
+
+	var conflict *TargetConflictError
+	assertEq(t, errors.As(Apply(entityId, Options{}), &conflict), true)
+	assertEq(t, conflict.IsRepo, false)
+	assertErrNil(t, Apply(entityId, Options{Force: true}), "Force-apply failed")
+	assertEq(t, gitHead(t, target), gitHead(t, source))
+	entries, err := Quarantined()
+	assertErrNil(t, err, "Cannot list quarantine")
+	assertEq(t, len(entries), 1)
+	content, err := ioutil.ReadFile(path.Join(stateDir, "quarantine", entries[0].ID))
+	assertErrNil(t, err, "Cannot read quarantined file")
+	assertEq(t, string(content), "precious\n")
+}
+
+func TestDiff(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+
+	// no drift
+	var err error
+	diffOutput := getFunctionOutput(func() { err = Diff(entityId, os.Stdout, Options{}) })
+	assertErrNil(t, err, "Diff failed")
+	assertEq(t, diffOutput, "")
+
+	// local commit, unstaged change and untracked file
+	makeTemporaryCommit(t, target)
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "tracked"), []byte("a\n"), 0644), "Cannot write file")
+	assertErrNil(t, runGitInDir(false, target, "add", "tracked"), "Cannot add file")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "tracked"), []byte("b\n"), 0644), "Cannot write file")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "untracked"), []byte("c\n"), 0644), "Cannot write file")
+
+	diffOutput = getFunctionOutput(func() { err = Diff(entityId, os.Stdout, Options{}) })
+	assertErrNil(t, err, "Diff failed")
+	for _, expected := range []string{"+++ b/tracked\n", "+b\n", "+++ b/untracked\n", "+c\n"} {
+		if !strings.Contains(diffOutput, expected) {
+			t.Fatalf("Expected diff to contain %q, found:\n%s", expected, diffOutput)
+		}
+	}
+	assertEq(t, strings.Count(diffOutput, "new file mode"), 3) // commit, staged file, untracked file
+}
+
+func TestDiffMissingTarget(t *testing.T) {
+	entityId, target := makeTemporaryApplyEnv(t, "TestDiffMissingTarget_testUrl", "main")
+
+	var err error
+	diffOutput := getFunctionOutput(func() { err = Diff(entityId, os.Stdout, Options{}) })
+	assertErrNil(t, err, "Diff failed")
+	expected := "# " + target + " does not exist and would be cloned\n"
+	expected += "--- " + target + " (desired)\n"
+	expected += "+++ /dev/null\n"
+	expected += "@@ -1,2 +0,0 @@\n"
+	expected += "-url=TestDiffMissingTarget_testUrl\n"
+	expected += "-revision=main\n"
+	assertEq(t, diffOutput, expected)
+}
+
+func TestScanJSON(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	entityFile := path.Join(os.Getenv("HOLO_RESOURCE_DIR"), entityId)
+	content := "url=" + source + "\npath=" + target + "\nrevision=main\ntimeout=30s\n"
+	assertErrNil(t, ioutil.WriteFile(entityFile, []byte(content), 0644), "Cannot write entity file")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+
+	var err error
+	output := getFunctionOutput(func() { err = Scan(os.Stdout, Options{Format: FormatJSON}) })
+	assertErrNil(t, err, "Scan failed")
+	var entries []map[string]interface{}
+	assertErrNil(t, json.Unmarshal([]byte(output), &entries), "Cannot parse JSON output")
+	assertEq(t, len(entries), 1)
+	assertEq(t, entries[0]["entity"], entityId)
+	assertEq(t, entries[0]["source"], entityFile)
+	assertEq(t, entries[0]["url"], source)
+	assertEq(t, entries[0]["path"], target)
+	assertEq(t, entries[0]["revision"], "main")
+	assertEq(t, entries[0]["timeout"], "30s")
+	assertEq(t, entries[0]["retries"], "")
+	assertEq(t, entries[0]["state"], StateUpToDate)
+	assertEq(t, entries[0]["head"], gitHead(t, source))
+
+	// one line per entity, and the state changes with the repository
+	assertErrNil(t, os.RemoveAll(target), "Cannot remove target")
+	output = getFunctionOutput(func() { err = Scan(os.Stdout, Options{Format: FormatJSONLines}) })
+	assertErrNil(t, err, "Scan failed")
+	assertEq(t, strings.Count(output, "\n"), 1)
+	var entry scanEntry
+	assertErrNil(t, json.Unmarshal([]byte(output), &entry), "Cannot parse JSON Lines output")
+	assertEq(t, entry.State, StateMissing)
+	assertEq(t, entry.LastApplied.Commit, gitHead(t, source))
+}
+
+func TestValidate(t *testing.T) {
+
+	// create temporary directory with a valid and an invalid entity file
+	tempDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	validId := path.Base(makeTemporaryEntityFile(t, tempDir, "TestValidate_testUrl", "/TestValidate_testPath", ""))
+	err = ioutil.WriteFile(path.Join(tempDir, "invalid"), []byte("url=x\nrevision=main\n"), 0644)
+	assertErrNil(t, err, "Cannot write invalid entity file")
+	os.Setenv("HOLO_RESOURCE_DIR", tempDir)
+
+	diags, err := Validate(validId, Options{})
+	assertErrNil(t, err, "Valid entity did not validate")
+	assertEq(t, len(diags), 0)
+
+	diags, err = Validate("invalid", Options{})
+	assertErrNil(t, err, "Cannot validate invalid entity")
+	assertEq(t, len(diags), 1)
+	assertEq(t, diags[0].String(), path.Join(tempDir, "invalid")+": missing key 'path'")
+}
+
+func TestScanBrokenEntity(t *testing.T) {
+
+	// create temporary directory with a valid and a broken entity file
+	tempDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	entityFilePath := makeTemporaryEntityFile(t, tempDir, "TestScanBrokenEntity_testUrl", "/TestScanBrokenEntity_testPath", "")
+	err = ioutil.WriteFile(path.Join(tempDir, "broken"), []byte("no key-value pair\n"), 0644)
+	assertErrNil(t, err, "Cannot write broken entity file")
+
+	// valid entity is still printed, but the error is returned
+	os.Setenv("HOLO_RESOURCE_DIR", tempDir)
+	scanOutput := getFunctionOutput(func() { err = Scan(os.Stdout, Options{}) })
+	assertEq(t, strings.HasPrefix(scanOutput, "ENTITY: git-repo:"+path.Base(entityFilePath)+"\n"), true)
+	broken, ok := err.(*BrokenEntitiesError)
+	if !ok {
+		t.Fatalf("Expected *BrokenEntitiesError, got %v", err)
+	}
+	assertEq(t, len(broken.Errors), 1)
+}
@@ -0,0 +1,142 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+)
+
+// describeRevision returns a human readable description of revision and
+// the commit it resolves to.
+func describeRevision(revision string, commit string) string {
+	desc := revision
+	if revision == "" {
+		desc = "the default branch"
+	}
+	if commit == "" {
+		return desc + " (unresolved)"
+	}
+	return desc + " (" + commit + ")"
+}
+
+// incomingCommits lists the commits that checking out commit would add
+// to HEAD of the git repository denoted by path, one line per commit.
+// Commits that were not fetched yet cannot be listed, but HEAD and the
+// tip of the remote (see remoteCommit) still tell whether there are any.
+func incomingCommits(path string, commit string) []string {
+	if commit == "" {
+		return []string{"incoming commits: unknown, the revision cannot be resolved"}
+	}
+	head, err := currentBackend.Resolve(path, "HEAD")
+	if err == nil && head == commit {
+		return []string{"incoming commits: none"}
+	}
+	if !refExists(path, commit) {
+		return []string{"incoming commits: not fetched yet, HEAD moves from " + head + " to " + commit}
+	}
+	commits, err := currentBackend.Log(path, commit)
+	if err != nil || len(commits) == 0 {
+		return []string{"incoming commits: none"}
+	}
+	lines := []string{"incoming commits:"}
+	for _, line := range commits {
+		lines = append(lines, "  "+line)
+	}
+	return lines
+}
+
+// planApply returns the actions applying entity e would take, one human
+// readable line each. It uses the same decisions as Apply (see
+// decideApply), resolves the revision against the remote, but does not
+// change anything.
+func planApply(e Entity, force bool) ([]string, error) {
+	decision, err := decideApply(e, force)
+	var conflict *TargetConflictError
+	if errors.As(err, &conflict) {
+		return []string{"nothing, requires --force to overwrite: " + conflict.Error()}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the revision against the remote
+	policy, err := e.networkPolicy()
+	if err != nil {
+		return nil, err
+	}
+	repoPath := ""
+	if decision == decideUpdate || decision == decideNotChanged {
+		repoPath = e.Path
+	}
+	commit, ok, err := remoteCommit(repoPath, e.URL, e.Revision, policy)
+	if err != nil || !ok {
+		commit = ""
+	}
+	revision := describeRevision(e.Revision, commit)
+	cloneActions := []string{
+		"clone " + e.URL + " into " + e.Path,
+		"check out " + revision,
+	}
+	if policy.offline {
+		cloneActions = []string{"nothing, cannot clone " + e.URL + " while offline"}
+	}
+
+	switch decision {
+	case decideNotChanged:
+		return []string{"nothing, " + e.Path + " already is at " + revision}, nil
+
+	case decideUpdate:
+		var actions []string
+		origin, _ := originURL(e.Path)
+		if origin != e.URL {
+			actions = append(actions, "point origin to "+e.URL+" (was "+origin+")")
+		}
+		if force {
+			actions = append(actions, "save HEAD and uncommitted changes below "+backupRefPrefix)
+		}
+		if !policy.offline {
+			actions = append(actions, "fetch from "+e.URL)
+		}
+		actions = append(actions, "check out "+revision+", fast-forwarding it if it is a branch")
+		actions = append(actions, incomingCommits(e.Path, commit)...)
+		if force && checkSafeToDelete(e.Path) == nil {
+			actions = append(actions, "if that fails: move "+e.Path+" into quarantine and clone it anew")
+		}
+		return actions, nil
+
+	case decideReplace:
+		if err := checkSafeToDelete(e.Path); err != nil {
+			return []string{"nothing, " + err.Error()}, nil
+		}
+		return append([]string{"move " + e.Path + " into quarantine (not a git repository)"}, cloneActions...), nil
+	}
+	return cloneActions, nil
+}
+
+// Plan returns what applying the entity with ID entityId would do, one
+// human readable action per line, e.g. "clone <url> into <path>". With
+// opts.Force, it shows what force-applying would do. Nothing is changed.
+func Plan(entityId string, opts Options) ([]string, error) {
+	e, err := ParseEntity(entityId, opts)
+	if err != nil {
+		return nil, err
+	}
+	return planApply(e, opts.Force)
+}
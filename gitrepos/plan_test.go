@@ -0,0 +1,81 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"os"
+	"testing"
+)
+
+// assertActions fails if the planned actions for e are not as expected.
+func assertActions(t *testing.T, e Entity, force bool, expected ...string) {
+	actions, err := planApply(e, force)
+	assertErrNil(t, err, "Cannot plan")
+	assertEq(t, len(actions), len(expected))
+	for i := range expected {
+		assertEq(t, actions[i], expected[i])
+	}
+}
+
+func TestPlan(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	first := gitHead(t, source)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	e, err := ParseEntity(entityId, Options{})
+	assertErrNil(t, err, "Cannot parse entity")
+
+	// missing target
+	assertActions(t, e, false,
+		"clone "+source+" into "+target,
+		"check out main ("+first+")")
+
+	// target is not a repo
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+	assertActions(t, e, false,
+		"nothing, requires --force to overwrite: "+target+" is not a git repository")
+	assertActions(t, e, true,
+		"move "+target+" into quarantine (not a git repository)",
+		"clone "+source+" into "+target,
+		"check out main ("+first+")")
+	_, err = os.Stat(target)
+	assertErrNil(t, err, "Plan changed the target")
+	assertErrNil(t, os.Remove(target), "Cannot remove target")
+
+	// up to date
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	assertActions(t, e, false, "nothing, "+target+" already is at main ("+first+")")
+
+	// new upstream commit
+	second := makeTemporaryCommit(t, source)
+	assertActions(t, e, true,
+		"save HEAD and uncommitted changes below refs/holo-backup/",
+		"fetch from "+source,
+		"check out main ("+second+"), fast-forwarding it if it is a branch",
+		"incoming commits: not fetched yet, HEAD moves from "+first+" to "+second,
+		"if that fails: move "+target+" into quarantine and clone it anew")
+	assertEq(t, gitHead(t, target), first)
+
+	// new upstream commit that was already fetched
+	assertErrNil(t, runGitInDir(false, target, "fetch", "--quiet"), "Cannot fetch")
+	actions, err := planApply(e, true)
+	assertErrNil(t, err, "Cannot plan")
+	assertEq(t, actions[3], "incoming commits:")
+	assertEq(t, actions[4], "  "+second[:7]+" temporary commit")
+}
@@ -0,0 +1,174 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file contains parsers for the machine readable output of git
+// commands and functions returning it as Go values.
+
+// statusEntry is a changed or untracked file as reported by
+// 'git status --porcelain -z'.
+type statusEntry struct {
+	Index    byte   // status in the index, e.g. 'M', 'A', '?' for untracked
+	Worktree byte   // status in the worktree
+	Path     string // relative to the top of the worktree
+	OrigPath string // where the file was renamed or copied from, or emptystring
+}
+
+// Untracked reports whether the file is untracked.
+func (s statusEntry) Untracked() bool {
+	return s.Index == '?'
+}
+
+// parseStatus parses the output of 'git status --porcelain -z'.
+func parseStatus(out string) ([]statusEntry, error) {
+	var entries []statusEntry
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if field == "" {
+			continue
+		}
+		if len(field) < 4 || field[2] != ' ' {
+			return nil, fmt.Errorf("unexpected output of git status: '%s'", field)
+		}
+		entry := statusEntry{Index: field[0], Worktree: field[1], Path: field[3:]}
+		// renames and copies are followed by the original path
+		if entry.Index == 'R' || entry.Index == 'C' {
+			if i+1 >= len(fields) || fields[i+1] == "" {
+				return nil, fmt.Errorf("unexpected output of git status: missing original path of '%s'", entry.Path)
+			}
+			i++
+			entry.OrigPath = fields[i]
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// gitStatus returns the changed and untracked files in the worktree of
+// the git repository denoted by path. Untracked directories are listed
+// file by file.
+func gitStatus(path string) ([]statusEntry, error) {
+	out, err := gitRawOutput(path, "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(out)
+}
+
+// gitRef is a ref and the object it points to.
+type gitRef struct {
+	Name   string
+	Object string
+}
+
+// parseRefs parses lists of refs in the format '<object> <ref>' (or
+// with a tab in between) as printed by ls-remote, show-ref and
+// for-each-ref with --format='%(objectname) %(refname)'.
+func parseRefs(out string) ([]gitRef, error) {
+	var refs []gitRef
+	for _, line := range strings.Split(out, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("unexpected line in list of refs: '%s'", line)
+		}
+		refs = append(refs, gitRef{Name: fields[1], Object: fields[0]})
+	}
+	return refs, nil
+}
+
+// listRefs returns the refs below prefix in the git repository denoted
+// by path.
+func listRefs(path string, prefix string) ([]gitRef, error) {
+	out, err := gitOutput(path, "for-each-ref", "--format=%(objectname) %(refname)", prefix)
+	if err != nil {
+		return nil, err
+	}
+	return parseRefs(out)
+}
+
+// parseRemoteURLs parses the output of
+// 'git config --get-regexp ^remote\..*\.url$' into a map from remote
+// names to URLs.
+func parseRemoteURLs(out string) (map[string]string, error) {
+	urls := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		keyValue := strings.SplitN(line, " ", 2)
+		key := keyValue[0]
+		if len(keyValue) != 2 || !strings.HasPrefix(key, "remote.") || !strings.HasSuffix(key, ".url") {
+			return nil, fmt.Errorf("unexpected remote url config: '%s'", line)
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(key, "remote."), ".url")
+		urls[name] = keyValue[1]
+	}
+	return urls, nil
+}
+
+// remoteURLs returns the URLs of all remotes of the git repository
+// denoted by path, as configured (i.e. without url.*.insteadOf applied).
+func remoteURLs(path string) (map[string]string, error) {
+	out, err := gitOutput(path, "config", "--get-regexp", `^remote\..*\.url$`)
+	var gitErr *GitError
+	if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+		// config exits with 1 if nothing matches
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseRemoteURLs(out)
+}
+
+// originURL returns the URL of the remote origin of the git repository
+// denoted by path, or emptystring if there is no origin.
+func originURL(path string) (string, error) {
+	urls, err := currentBackend.RemoteURLs(path)
+	if err != nil {
+		return "", err
+	}
+	return urls["origin"], nil
+}
+
+// parseAheadBehind parses the output of
+// 'git rev-list --left-right --count <commit>...HEAD'.
+func parseAheadBehind(out string) (ahead int, behind int, err error) {
+	fields := strings.Fields(out)
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("unexpected output of git rev-list: '%s'", out)
+	}
+	if behind, err = strconv.Atoi(fields[0]); err != nil {
+		return 0, 0, err
+	}
+	ahead, err = strconv.Atoi(fields[1])
+	return ahead, behind, err
+}
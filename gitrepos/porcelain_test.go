@@ -0,0 +1,90 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	entries, err := parseStatus(" M changed\x00R  new\x00old\x00?? dir/untracked file\x00")
+	assertErrNil(t, err, "Cannot parse status")
+	assertEq(t, len(entries), 3)
+	assertEq(t, entries[0], statusEntry{' ', 'M', "changed", ""})
+	assertEq(t, entries[1], statusEntry{'R', ' ', "new", "old"})
+	assertEq(t, entries[2], statusEntry{'?', '?', "dir/untracked file", ""})
+	assertEq(t, entries[2].Untracked(), true)
+
+	_, err = parseStatus("garbage\x00")
+	assertEq(t, err != nil, true)
+	_, err = parseStatus("R  new\x00")
+	assertEq(t, err != nil, true)
+}
+
+func TestParseRefs(t *testing.T) {
+	refs, err := parseRefs("1111\trefs/heads/main\n2222 refs/tags/v1\n")
+	assertErrNil(t, err, "Cannot parse refs")
+	assertEq(t, len(refs), 2)
+	assertEq(t, refs[0], gitRef{"refs/heads/main", "1111"})
+	assertEq(t, refs[1], gitRef{"refs/tags/v1", "2222"})
+	_, err = parseRefs("1111\n")
+	assertEq(t, err != nil, true)
+}
+
+func TestParseRemoteURLs(t *testing.T) {
+	urls, err := parseRemoteURLs("remote.origin.url https://example.com/a b\nremote.my.fork.url /tmp/fork\n")
+	assertErrNil(t, err, "Cannot parse remote urls")
+	assertEq(t, len(urls), 2)
+	assertEq(t, urls["origin"], "https://example.com/a b")
+	assertEq(t, urls["my.fork"], "/tmp/fork")
+	_, err = parseRemoteURLs("core.bare false\n")
+	assertEq(t, err != nil, true)
+}
+
+func TestParseAheadBehind(t *testing.T) {
+	ahead, behind, err := parseAheadBehind("3\t1\n")
+	assertErrNil(t, err, "Cannot parse counts")
+	assertEq(t, ahead, 1)
+	assertEq(t, behind, 3)
+	_, _, err = parseAheadBehind("3")
+	assertEq(t, err != nil, true)
+}
+
+func TestGitStatus(t *testing.T) {
+	repo := makeTemporaryGitRepo(t)
+	clean, err := isClean(repo)
+	assertErrNil(t, err, "Cannot get status")
+	assertEq(t, clean, true)
+
+	assertErrNil(t, os.MkdirAll(filepath.Join(repo, "dir"), 0755), "Cannot create directory")
+	assertErrNil(t, ioutil.WriteFile(filepath.Join(repo, "dir", "untracked"), nil, 0644), "Cannot create file")
+	entries, err := gitStatus(repo)
+	assertErrNil(t, err, "Cannot get status")
+	assertEq(t, len(entries), 1)
+	assertEq(t, entries[0].Path, "dir/untracked")
+	assertEq(t, entries[0].Untracked(), true)
+
+	origin, err := originURL(repo)
+	assertErrNil(t, err, "Cannot get origin")
+	assertEq(t, origin, "")
+}
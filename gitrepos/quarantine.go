@@ -0,0 +1,295 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// QuarantineEntry describes a target that was moved into quarantine
+// instead of being deleted.
+type QuarantineEntry struct {
+	ID           string    `json:"id"` // name of the directory in the quarantine area
+	Entity       string    `json:"entity"`
+	OriginalPath string    `json:"originalPath"`
+	MovedAt      time.Time `json:"movedAt"`
+}
+
+// quarantineRoot returns the directory targets are moved into instead of
+// being deleted. It is below HOLO_STATE_DIR or, if that is not set,
+// below HOLO_CACHE_DIR.
+func quarantineRoot() (string, error) {
+	for _, dir := range []string{stateDir(), os.Getenv("HOLO_CACHE_DIR")} {
+		if dir != "" {
+			return filepath.Join(dir, "quarantine"), nil
+		}
+	}
+	return "", errors.New("cannot quarantine: neither HOLO_STATE_DIR nor HOLO_CACHE_DIR is set")
+}
+
+// loadManifest returns the entries of the quarantine manifest, oldest first.
+func loadManifest(root string) ([]QuarantineEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "manifest.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read quarantine manifest: %w", err)
+	}
+	var entries []QuarantineEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("cannot parse quarantine manifest: %w", err)
+	}
+	return entries, nil
+}
+
+// saveManifest replaces the quarantine manifest with entries.
+func saveManifest(root string, entries []QuarantineEntry) error {
+	return writeJSONAtomic(filepath.Join(root, "manifest.json"), entries)
+}
+
+// moveTree moves the file or directory at src to dst. If they are on
+// different file systems, it is copied and then deleted.
+func moveTree(src string, dst string) error {
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if !errors.As(err, &linkErr) || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// copyTree recursively copies the file, symlink or directory at src to
+// dst, keeping ownership (as far as permitted), permissions and
+// modification times. Other files like FIFOs or devices cannot be
+// copied, so that they are left where they are.
+func copyTree(src string, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+
+	case info.IsDir():
+		if err := os.Mkdir(dst, 0700); err != nil {
+			return err
+		}
+		names, err := readDirNames(src)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := copyTree(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+				return err
+			}
+		}
+
+	case info.Mode().IsRegular():
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("cannot copy %s: not a regular file, directory or symlink", src)
+	}
+	return copyMetadata(dst, info)
+}
+
+// copyFile copies the content of the regular file src to the new file
+// dst.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// copyMetadata gives dst the owner, permissions and times of the file
+// described by info. Only root may give files away, so for others, the
+// owner is kept as far as they are allowed to.
+func copyMetadata(dst string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot get owner of %s", info.Name())
+	}
+	if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	// changing the owner clears the setuid and setgid bits, so set the
+	// permissions afterwards
+	if info.Mode()&os.ModeSymlink == 0 {
+		mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := os.Chmod(dst, mode); err != nil {
+			return err
+		}
+	}
+	times := []unix.Timespec{unix.NsecToTimespec(stat.Atim.Nano()), unix.NsecToTimespec(stat.Mtim.Nano())}
+	return unix.UtimesNanoAt(unix.AT_FDCWD, dst, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+// readDirNames returns the names of the entries of the directory dir.
+func readDirNames(dir string) ([]string, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdirnames(0)
+}
+
+// quarantine moves the target at path of the entity with ID entityId
+// into a new timestamped directory of the quarantine area and records
+// it in the manifest, so that it can be restored.
+func quarantine(entityId string, path string) (QuarantineEntry, error) {
+	root, err := quarantineRoot()
+	if err != nil {
+		return QuarantineEntry{}, err
+	}
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return QuarantineEntry{}, fmt.Errorf("cannot create quarantine directory: %w", err)
+	}
+
+	now := time.Now().UTC()
+	entry := QuarantineEntry{
+		ID:           now.Format("20060102T150405.000000000Z") + "-" + entityId,
+		Entity:       entityId,
+		OriginalPath: path,
+		MovedAt:      now,
+	}
+	if err := moveTree(path, filepath.Join(root, entry.ID)); err != nil {
+		return QuarantineEntry{}, fmt.Errorf("cannot move %s into quarantine: %w", path, err)
+	}
+
+	err = withStateLock(func() error {
+		entries, err := loadManifest(root)
+		if err != nil {
+			return err
+		}
+		return saveManifest(root, append(entries, entry))
+	})
+	if err != nil {
+		// the target is in the quarantine directory nonetheless, so tell the user where
+		return entry, fmt.Errorf("moved %s to %s, but %w", path, filepath.Join(root, entry.ID), err)
+	}
+	return entry, nil
+}
+
+// Quarantined returns the targets in quarantine, oldest first.
+func Quarantined() ([]QuarantineEntry, error) {
+	root, err := quarantineRoot()
+	if err != nil {
+		return nil, err
+	}
+	return loadManifest(root)
+}
+
+// Restore moves the quarantined target with the given ID, or the most
+// recently quarantined target of the entity with the given ID, back to
+// where it came from. An existing target is not overwritten. It returns
+// the entry of the restored target.
+func Restore(id string) (QuarantineEntry, error) {
+	entries, err := Quarantined()
+	if err != nil {
+		return QuarantineEntry{}, err
+	}
+
+	// find the entry, the latest one wins
+	index := -1
+	for i, entry := range entries {
+		if entry.ID == id || entry.Entity == id {
+			index = i
+		}
+	}
+	if index < 0 {
+		return QuarantineEntry{}, errors.New("nothing in quarantine for " + id)
+	}
+	entry := entries[index]
+
+	// move it back
+	lock, err := lockTarget(entry.OriginalPath)
+	if err != nil {
+		return entry, err
+	}
+	defer lock.unlock()
+	if _, err := os.Lstat(entry.OriginalPath); !os.IsNotExist(err) {
+		return entry, errors.New("cannot restore " + entry.ID + ": " + entry.OriginalPath + " exists")
+	}
+	return entry, unquarantine(entry)
+}
+
+// unquarantine moves the quarantined target of entry back to where it
+// came from and removes entry from the manifest. The caller makes sure
+// that nothing is in the way.
+func unquarantine(entry QuarantineEntry) error {
+	root, err := quarantineRoot()
+	if err != nil {
+		return err
+	}
+	if err := moveTree(filepath.Join(root, entry.ID), entry.OriginalPath); err != nil {
+		return fmt.Errorf("cannot restore %s: %w", entry.ID, err)
+	}
+
+	// the manifest may have changed in the meantime, so reload it
+	return withStateLock(func() error {
+		entries, err := loadManifest(root)
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if entries[i].ID == entry.ID {
+				return saveManifest(root, append(entries[:i], entries[i+1:]...))
+			}
+		}
+		return nil
+	})
+}
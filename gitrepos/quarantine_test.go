@@ -0,0 +1,140 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestQuarantineRestore(t *testing.T) {
+	stateDir := setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, os.MkdirAll(path.Join(target, "work"), 0755), "Cannot create target")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "work", "file"), []byte("precious\n"), 0644), "Cannot write file")
+
+	// force-apply moves the target into quarantine
+	assertErrNil(t, Apply(entityId, Options{Force: true}), "Force-apply failed")
+	entries, err := loadManifest(path.Join(stateDir, "quarantine"))
+	assertErrNil(t, err, "Cannot load manifest")
+	assertEq(t, len(entries), 1)
+	assertEq(t, entries[0].Entity, entityId)
+	assertEq(t, entries[0].OriginalPath, target)
+	content, err := ioutil.ReadFile(path.Join(stateDir, "quarantine", entries[0].ID, "work", "file"))
+	assertErrNil(t, err, "Cannot read quarantined file")
+	assertEq(t, string(content), "precious\n")
+
+	// listing
+	listed, err := Quarantined()
+	assertErrNil(t, err, "Cannot list quarantine")
+	assertEq(t, len(listed), 1)
+	assertEq(t, listed[0].ID, entries[0].ID)
+
+	// restoring refuses to overwrite the clone
+	_, err = Restore(entityId)
+	assertEq(t, err != nil, true)
+
+	// restoring after the clone is gone
+	assertErrNil(t, os.RemoveAll(target), "Cannot remove clone")
+	restored, err := Restore(entityId)
+	assertErrNil(t, err, "Cannot restore")
+	assertEq(t, restored.ID, entries[0].ID)
+	content, err = ioutil.ReadFile(path.Join(target, "work", "file"))
+	assertErrNil(t, err, "Cannot read restored file")
+	assertEq(t, string(content), "precious\n")
+	entries, err = loadManifest(path.Join(stateDir, "quarantine"))
+	assertErrNil(t, err, "Cannot load manifest")
+	assertEq(t, len(entries), 0)
+}
+
+func TestCopyTree(t *testing.T) {
+	src, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	assertErrNil(t, os.Mkdir(path.Join(src, "dir"), 0700), "Cannot create directory")
+	assertErrNil(t, ioutil.WriteFile(path.Join(src, "dir", "file"), []byte("content"), 0600), "Cannot write file")
+	assertErrNil(t, os.Symlink("dir/file", path.Join(src, "link")), "Cannot create symlink")
+	mtime := time.Date(2001, 2, 3, 4, 5, 6, 0, time.Local)
+	assertErrNil(t, os.Chtimes(path.Join(src, "dir", "file"), mtime, mtime), "Cannot set file time")
+	assertErrNil(t, os.Chtimes(path.Join(src, "dir"), mtime, mtime), "Cannot set directory time")
+	dst := src + "-copy"
+
+	assertErrNil(t, copyTree(src, dst), "Cannot copy tree")
+	content, err := ioutil.ReadFile(path.Join(dst, "link"))
+	assertErrNil(t, err, "Cannot read through copied symlink")
+	assertEq(t, string(content), "content")
+	info, err := os.Stat(path.Join(dst, "dir"))
+	assertErrNil(t, err, "Cannot stat copied directory")
+	assertEq(t, info.Mode().Perm(), os.FileMode(0700))
+	assertEq(t, info.ModTime().Equal(mtime), true)
+	info, err = os.Stat(path.Join(dst, "dir", "file"))
+	assertErrNil(t, err, "Cannot stat copied file")
+	assertEq(t, info.ModTime().Equal(mtime), true)
+
+	// a FIFO is not opened, which would block, but refused
+	assertErrNil(t, syscall.Mkfifo(path.Join(src, "fifo"), 0600), "Cannot create FIFO")
+	assertEq(t, copyTree(src, src+"-fifo") != nil, true)
+}
+
+// Apply: Target exists and forced and target is no repo and revision non-existent
+// => clone fails, target is left alone
+func TestApplyForceNoRepoCloneFails(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "nonexistent-revision")
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "file"), []byte("precious\n"), 0644), "Cannot write file")
+
+	assertEq(t, Apply(entityId, Options{Force: true}) != nil, true)
+	content, err := ioutil.ReadFile(path.Join(target, "file"))
+	assertErrNil(t, err, "Cannot read file in target")
+	assertEq(t, string(content), "precious\n")
+}
+
+// Apply: Target exists and forced and target is no repo, but the clone
+// cannot be moved into place
+// => the quarantined target is moved back
+func TestApplyForceNoRepoInstallFails(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	defer func(saved func(string, string) error) { renameStaged = saved }(renameStaged)
+	renameStaged = func(string, string) error { return errors.New("rename failed") }
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "file"), []byte("precious\n"), 0644), "Cannot write file")
+
+	assertEq(t, Apply(entityId, Options{Force: true}) != nil, true)
+	content, err := ioutil.ReadFile(path.Join(target, "file"))
+	assertErrNil(t, err, "Cannot read file in target")
+	assertEq(t, string(content), "precious\n")
+	root, err := quarantineRoot()
+	assertErrNil(t, err, "Cannot find quarantine")
+	entries, err := loadManifest(root)
+	assertErrNil(t, err, "Cannot load manifest")
+	assertEq(t, len(entries), 0)
+}
@@ -0,0 +1,79 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// gitResult is what a git command printed and how it exited.
+type gitResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int // -1 if git did not exit by itself, e.g. when it was killed
+}
+
+// gitRunner runs git commands. All git commands go through the runner in
+// the package variable runner, so that tests can replace it by a fake.
+type gitRunner interface {
+	// Run runs git with the given arguments in the repository dir, or in
+	// the current directory if dir is emptystring. It returns an error if
+	// git could not be run or did not exit with status 0, but the result
+	// is filled in either way.
+	Run(ctx context.Context, dir string, arguments []string) (gitResult, error)
+}
+
+// runner is the gitRunner used by execGit.
+var runner gitRunner = execRunner{}
+
+// execRunner runs the git binary from $PATH (see runCmd).
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, dir string, arguments []string) (gitResult, error) {
+	cmdArgs := arguments
+	if dir != "" {
+		cmdArgs = append([]string{"-C", dir}, arguments...)
+	}
+	cmd := exec.Command("git", cmdArgs...)
+	if !interactive() {
+		// nobody could answer a prompt, so let git fail instead of
+		// waiting for an answer until it times out
+		cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	// show progress and errors right away, not only when git is done
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err := runCmd(ctx, cmd)
+
+	result := gitResult{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		result.ExitCode = -1
+	}
+	return result, err
+}
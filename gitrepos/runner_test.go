@@ -0,0 +1,99 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRunner is a gitRunner that returns canned results instead of
+// running git. Commands without a result fail like git does.
+type fakeRunner struct {
+	results map[string]gitResult // by arguments joined with spaces
+	calls   []string
+}
+
+func (r *fakeRunner) Run(ctx context.Context, dir string, arguments []string) (gitResult, error) {
+	command := strings.Join(arguments, " ")
+	r.calls = append(r.calls, command)
+	result, ok := r.results[command]
+	if !ok {
+		result = gitResult{Stderr: "fatal: unexpected command " + command, ExitCode: 128}
+	}
+	if result.ExitCode != 0 {
+		return result, fmt.Errorf("exit status %d", result.ExitCode)
+	}
+	return result, nil
+}
+
+// useRunner makes all git commands go to r until the returned function
+// is called.
+func useRunner(r gitRunner) (restore func()) {
+	saved := runner
+	runner = r
+	return func() { runner = saved }
+}
+
+func TestFakeRemoteCommit(t *testing.T) {
+	fake := &fakeRunner{results: map[string]gitResult{
+		"ls-remote https://example.com/repo refs/heads/v1 refs/tags/v1^{} refs/tags/v1": {
+			Stdout: "1111\trefs/tags/v1\n2222\trefs/tags/v1^{}\n",
+		},
+	}}
+	defer useRunner(fake)()
+
+	commit, ok, err := remoteCommit("", "https://example.com/repo", "v1", networkPolicy{})
+	assertErrNil(t, err, "Cannot resolve revision")
+	assertEq(t, ok, true)
+	assertEq(t, commit, "2222")
+
+	_, _, err = remoteCommit("", "https://example.com/repo", "v2", networkPolicy{})
+	assertEq(t, err != nil, true)
+	assertEq(t, len(fake.calls), 2)
+}
+
+func TestFakeStatus(t *testing.T) {
+	repo := t.TempDir()
+	assertErrNil(t, os.Mkdir(filepath.Join(repo, ".git"), 0755), "Cannot create .git")
+	fake := &fakeRunner{results: map[string]gitResult{
+		`config --get-regexp ^remote\..*\.url$`:                        {Stdout: "remote.origin.url https://example.com/repo\n"},
+		"rev-parse --verify --quiet HEAD^{commit}":                     {Stdout: "1111\n"},
+		"status --porcelain -z --untracked-files=all":                  {},
+		"rev-parse --verify --quiet refs/remotes/origin/main^{commit}": {Stdout: "2222\n"},
+		"rev-list --left-right --count 2222...HEAD":                    {Stdout: "2\t0\n"},
+	}}
+	defer useRunner(fake)()
+
+	e := Entity{ID: "repo", URL: "https://example.com/repo", Path: repo, Revision: "main"}
+	s := getStatus(e)
+	assertEq(t, s.Error, "")
+	assertEq(t, s.State, StateBehind)
+	assertEq(t, s.Behind, 2)
+	assertEq(t, s.Desired, "2222")
+
+	// the same without an origin
+	fake.results[`config --get-regexp ^remote\..*\.url$`] = gitResult{ExitCode: 1}
+	assertEq(t, getStatus(e).State, StateDifferentRemote)
+}
@@ -0,0 +1,144 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// allowedRoots returns the directories below which targets may be
+// deleted, as configured in HOLO_GIT_REPOS_ALLOWED_ROOTS (separated by
+// ':'). If nothing is configured, nil is returned, meaning every
+// directory is allowed.
+func allowedRoots() []string {
+	var roots []string
+	for _, root := range filepath.SplitList(os.Getenv("HOLO_GIT_REPOS_ALLOWED_ROOTS")) {
+		if root != "" {
+			roots = append(roots, filepath.Clean(root))
+		}
+	}
+	return roots
+}
+
+// isBelow checks whether path is strictly below dir. Both must be clean.
+func isBelow(path string, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// homeDirs returns the home directories of all users in /etc/passwd
+// and of the current user.
+func homeDirs() []string {
+	var homes []string
+	if home, err := os.UserHomeDir(); err == nil {
+		homes = append(homes, filepath.Clean(home))
+	}
+	passwd, err := os.Open("/etc/passwd")
+	if err != nil {
+		return homes
+	}
+	defer passwd.Close()
+
+	// lines are of the format name:password:uid:gid:gecos:home:shell
+	scanner := bufio.NewScanner(passwd)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && filepath.IsAbs(fields[5]) {
+			homes = append(homes, filepath.Clean(fields[5]))
+		}
+	}
+	return homes
+}
+
+// isMountPoint checks whether path is a mount point, either because it
+// is listed in /proc/self/mounts or because it is on a different device
+// than its parent directory.
+func isMountPoint(path string) bool {
+	if mounts, err := os.Open("/proc/self/mounts"); err == nil {
+		defer mounts.Close()
+
+		// lines are of the format device mountpoint type options dump pass
+		scanner := bufio.NewScanner(mounts)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) > 1 && unescapeMountPath(fields[1]) == path {
+				return true
+			}
+		}
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink != 0 {
+		return false
+	}
+	parentInfo, err := os.Stat(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	parentStat, parentOk := parentInfo.Sys().(*syscall.Stat_t)
+	return ok && parentOk && stat.Dev != parentStat.Dev
+}
+
+// unescapeMountPath undoes the octal escaping of spaces, tabs, newlines
+// and backslashes in /proc/self/mounts.
+func unescapeMountPath(path string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(path)
+}
+
+// checkSafeToDelete returns an *UnsafePathError if path must not be
+// deleted recursively: if it is not absolute, a filesystem root, a home
+// directory or one of its parents, a mount point, or outside of the
+// allowed roots (see allowedRoots).
+func checkSafeToDelete(path string) error {
+	unsafe := func(reason string) error {
+		return &UnsafePathError{Path: path, Reason: reason}
+	}
+
+	if !filepath.IsAbs(path) {
+		return unsafe("path is not absolute")
+	}
+	clean := filepath.Clean(path)
+	if clean == "/" {
+		return unsafe("path is the filesystem root")
+	}
+	for _, home := range homeDirs() {
+		if clean == home || isBelow(home, clean) {
+			return unsafe("path is or contains the home directory " + home)
+		}
+	}
+	if isMountPoint(clean) {
+		return unsafe("path is a mount point")
+	}
+
+	roots := allowedRoots()
+	if roots == nil {
+		return nil
+	}
+	for _, root := range roots {
+		if isBelow(clean, root) {
+			return nil
+		}
+	}
+	return unsafe("path is not below any of HOLO_GIT_REPOS_ALLOWED_ROOTS")
+}
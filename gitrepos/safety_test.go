@@ -0,0 +1,78 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// assertUnsafe fails if path is considered safe to delete.
+func assertUnsafe(t *testing.T, path string) {
+	if _, ok := checkSafeToDelete(path).(*UnsafePathError); !ok {
+		t.Fatalf("Expected %s to be unsafe to delete", path)
+	}
+}
+
+func TestCheckSafeToDelete(t *testing.T) {
+	tempDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	target := path.Join(tempDir, "repo")
+	assertErrNil(t, checkSafeToDelete(target), "Target is not safe to delete")
+
+	assertUnsafe(t, "relative/path")
+	assertUnsafe(t, "/")
+	assertUnsafe(t, "/proc") // mount point
+
+	// home directory and its parents
+	origHome := os.Getenv("HOME")
+	defer os.Setenv("HOME", origHome)
+	os.Setenv("HOME", target)
+	assertUnsafe(t, target)
+	assertUnsafe(t, tempDir)
+	os.Setenv("HOME", origHome)
+
+	// allowed roots
+	defer os.Unsetenv("HOLO_GIT_REPOS_ALLOWED_ROOTS")
+	os.Setenv("HOLO_GIT_REPOS_ALLOWED_ROOTS", "/nonexistent:"+tempDir)
+	assertErrNil(t, checkSafeToDelete(target), "Target below allowed root is not safe to delete")
+	assertUnsafe(t, tempDir)
+	os.Setenv("HOLO_GIT_REPOS_ALLOWED_ROOTS", "/nonexistent")
+	assertUnsafe(t, target)
+}
+
+// Apply: Target exists and forced and target is no repo and outside of the allowed roots
+// => refuse to delete
+func TestApplyForceNoRepoUnsafe(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "")
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+	defer os.Unsetenv("HOLO_GIT_REPOS_ALLOWED_ROOTS")
+	os.Setenv("HOLO_GIT_REPOS_ALLOWED_ROOTS", "/nonexistent")
+
+	err := Apply(entityId, Options{Force: true})
+	if _, ok := err.(*UnsafePathError); !ok {
+		t.Fatalf("Expected *UnsafePathError, got %v", err)
+	}
+	_, err = os.Stat(target)
+	assertErrNil(t, err, "Target was deleted")
+}
@@ -0,0 +1,172 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// killGracePeriod is how long a git process group gets to exit after
+// SIGTERM before it is killed.
+const killGracePeriod = 5 * time.Second
+
+// gitContext is cancelled when we receive a termination signal. Every
+// git command is run with it or a context derived from it (see runCmd), so that no git process
+// outlives us.
+var gitContext = context.Background()
+
+var (
+	signalMutex    sync.Mutex
+	receivedSignal os.Signal
+	cancelGit      context.CancelFunc // cancels gitContext, nil unless we handle signals
+)
+
+// HandleSignals makes SIGINT, SIGTERM and SIGHUP cancel gitContext
+// instead of killing us right away, so that we can clean up. The
+// returned function stops the signal handling.
+func HandleSignals() (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signalMutex.Lock()
+	gitContext, cancelGit = ctx, cancel
+	signalMutex.Unlock()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		sig, ok := <-signals
+		if ok {
+			interrupt(sig)
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel()
+		signalMutex.Lock()
+		gitContext, cancelGit = context.Background(), nil
+		signalMutex.Unlock()
+	}
+}
+
+// interrupt records that we were interrupted by sig, unless we were
+// interrupted before, and cancels gitContext. It does nothing unless we
+// handle signals (see HandleSignals).
+func interrupt(sig os.Signal) {
+	signalMutex.Lock()
+	defer signalMutex.Unlock()
+	if cancelGit == nil {
+		return
+	}
+	if receivedSignal == nil {
+		receivedSignal = sig
+	}
+	cancelGit()
+}
+
+// InterruptedBy returns the signal that interrupted us, or nil.
+func InterruptedBy() os.Signal {
+	signalMutex.Lock()
+	defer signalMutex.Unlock()
+	return receivedSignal
+}
+
+// withoutCancellation runs f with git commands that cannot be cancelled.
+// This is used for rolling back after we have been interrupted.
+func withoutCancellation(f func() error) error {
+	saved := gitContext
+	gitContext = context.Background()
+	defer func() { gitContext = saved }()
+	return f()
+}
+
+// runCmd runs cmd in a process group of its own. When ctx is cancelled
+// (usually gitContext or a context derived from it), the whole process
+// group (i.e. git and its helpers like ssh) is sent SIGTERM and, if it
+// does not exit in time, SIGKILL.
+//
+// If we run on a terminal (see interactive), the process group is put
+// into the foreground while it runs, so that git and ssh can ask for
+// passwords, passphrases and host keys; a process group in the
+// background would be stopped by SIGTTIN instead. Since the terminal
+// then sends Ctrl-C to git only, git dying from SIGINT counts as our
+// interruption (see interrupt).
+func runCmd(ctx context.Context, cmd *exec.Cmd) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	foreground := interactive()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if foreground {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pgid := -cmd.Process.Pid // negative PIDs denote process groups in kill(2)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			syscall.Kill(pgid, syscall.SIGTERM)
+			select {
+			case <-done:
+			case <-time.After(killGracePeriod):
+				syscall.Kill(pgid, syscall.SIGKILL)
+			}
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	if foreground {
+		takeTerminal()
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGINT {
+			interrupt(syscall.SIGINT)
+		}
+	}
+	return err
+}
+
+// interactive reports whether we run on a terminal, i.e. stdin is one.
+func interactive() bool {
+	_, err := unix.IoctlGetTermios(int(os.Stdin.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// takeTerminal puts our process group back into the foreground of the
+// terminal after a git process group had it (see runCmd).
+func takeTerminal() {
+	// being in the background, we would be stopped by SIGTTOU otherwise
+	signal.Ignore(syscall.SIGTTOU)
+	unix.IoctlSetPointerInt(int(os.Stdin.Fd()), unix.TIOCSPGRP, syscall.Getpgrp())
+}
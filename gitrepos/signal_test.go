@@ -0,0 +1,178 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// isRunning checks whether the process with the given PID is running,
+// i.e. exists and is not a zombie.
+func isRunning(pid int) bool {
+	stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	// the state follows the command name in parentheses
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+// runCmd kills the whole process group when gitContext is cancelled
+func TestRunCmdCancel(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory")
+	pidFile := path.Join(dir, "pid")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	// the shell starts a child that would outlive it
+	start := time.Now()
+	cmd := exec.Command("sh", "-c", "sleep 30 & echo $! > "+pidFile+"; wait")
+	assertEq(t, runCmd(ctx, cmd) != nil, true)
+	assertEq(t, time.Since(start) < killGracePeriod, true)
+
+	data, err := ioutil.ReadFile(pidFile)
+	assertErrNil(t, err, "Cannot read PID of child")
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	assertErrNil(t, err, "Cannot parse PID of child")
+	for i := 0; i < 50 && isRunning(pid); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assertEq(t, isRunning(pid), false)
+
+	// nothing is started once cancelled
+	assertEq(t, runCmd(ctx, exec.Command("true")), context.Canceled)
+}
+
+// runCmd runs git in a process group of its own, but on our terminal
+func TestRunCmdProcessGroup(t *testing.T) {
+	// the shell prints its PID, process group ID and session ID
+	var out strings.Builder
+	cmd := exec.Command("sh", "-c", "echo $$; cut -d' ' -f5,6 /proc/$$/stat")
+	cmd.Stdout = &out
+	assertErrNil(t, runCmd(context.Background(), cmd), "Cannot run shell")
+	fields := strings.Fields(out.String())
+	assertEq(t, len(fields), 3)
+	assertEq(t, fields[1], fields[0])
+	assertEq(t, fields[2], strconv.Itoa(getsid(t)))
+
+	// prompting is only turned off if nobody could answer
+	result, err := runner.Run(context.Background(), "", []string{"-c", "alias.prompt=!printenv GIT_TERMINAL_PROMPT; true", "prompt"})
+	assertErrNil(t, err, "Cannot run git")
+	if !interactive() {
+		assertEq(t, strings.TrimSpace(result.Stdout), "0")
+	}
+}
+
+// getsid returns our session ID.
+func getsid(t *testing.T) int {
+	stat, err := ioutil.ReadFile("/proc/self/stat")
+	assertErrNil(t, err, "Cannot read own stat")
+	// the session ID is the fourth field after the command name
+	fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+	sid, err := strconv.Atoi(fields[3])
+	assertErrNil(t, err, "Cannot parse session ID")
+	return sid
+}
+
+// git dying from Ctrl-C on the terminal interrupts us, too, but only if
+// we handle signals
+func TestInterrupt(t *testing.T) {
+	defer func() { receivedSignal = nil }()
+	interrupt(syscall.SIGINT)
+	assertEq(t, InterruptedBy(), nil)
+
+	stop := HandleSignals()
+	interrupt(syscall.SIGINT)
+	interrupt(syscall.SIGTERM)
+	assertEq(t, InterruptedBy(), os.Signal(syscall.SIGINT))
+	assertEq(t, gitContext.Err(), context.Canceled)
+	stop()
+	assertEq(t, gitContext.Err(), nil)
+}
+
+func TestRollbackUpdate(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	first := gitHead(t, target)
+	snapshot, err := snapshotForRollback(target)
+	assertErrNil(t, err, "Cannot snapshot")
+
+	other := makeTemporaryGitRepo(t)
+	makeTemporaryCommit(t, source)
+	assertErrNil(t, update(target, source, "main", networkPolicy{}), "Update failed")
+	assertErrNil(t, setOrigin(target, other), "Cannot set origin")
+
+	// rolling back works even while cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gitContext = ctx
+	defer func() { gitContext = context.Background() }()
+	assertErrNil(t, rollbackUpdate(target, snapshot), "Rollback failed")
+	gitContext = context.Background()
+
+	assertEq(t, gitHead(t, target), first)
+	url, err := gitOutput(target, "remote", "get-url", "origin")
+	assertErrNil(t, err, "Cannot get url of origin")
+	assertEq(t, url, source)
+}
+
+// rolling back an update after a backup puts the changes back even though
+// the interrupted update left the worktree dirty
+func TestRollbackUpdateBackedUp(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	target := cloneTemporary(t, source)
+	first := gitHead(t, target)
+	snapshot, err := snapshotForRollback(target)
+	assertErrNil(t, err, "Cannot snapshot")
+
+	assertErrNil(t, ioutil.WriteFile(path.Join(target, "untracked"), []byte("mine\n"), 0644), "Cannot write file")
+	snapshot.backup, err = backup(target)
+	assertErrNil(t, err, "Backup failed")
+	makeTemporaryCommit(t, source)
+	assertErrNil(t, update(target, source, "main", networkPolicy{}), "Update failed")
+	tracked, err := gitOutput(target, "ls-files")
+	assertErrNil(t, err, "Cannot list tracked files")
+	trackedFile := path.Join(target, strings.Fields(tracked)[0])
+	assertErrNil(t, ioutil.WriteFile(trackedFile, []byte("half-updated\n"), 0644), "Cannot modify file")
+
+	assertErrNil(t, rollbackUpdate(target, snapshot), "Rollback failed")
+	assertEq(t, gitHead(t, target), first)
+	content, err := ioutil.ReadFile(path.Join(target, "untracked"))
+	assertErrNil(t, err, "Cannot read untracked file")
+	assertEq(t, string(content), "mine\n")
+	status, err := gitOutput(target, "status", "--porcelain")
+	assertErrNil(t, err, "Cannot get status")
+	assertEq(t, status, "?? untracked")
+	backups, err := backupRefs(target)
+	assertErrNil(t, err, "Cannot list backups")
+	assertEq(t, len(backups), 0)
+}
@@ -0,0 +1,218 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// appliedState is what we remember about the last successful apply of
+// an entity.
+type appliedState struct {
+	Commit    string    `json:"commit"` // resolved commit ID of the revision
+	URL       string    `json:"url"`
+	Path      string    `json:"path"`
+	Revision  string    `json:"revision"` // revision as given in the entity file
+	AppliedAt time.Time `json:"appliedAt"`
+}
+
+// stateDir returns the directory the state is kept in, or emptystring if
+// HOLO_STATE_DIR is not set. Without a state directory, no state is kept.
+func stateDir() string {
+	return os.Getenv("HOLO_STATE_DIR")
+}
+
+// stateFilePath returns the path of the state file of the entity with ID entityId.
+func stateFilePath(entityId string) string {
+	return filepath.Join(stateDir(), "applied", entityId+".json")
+}
+
+// loadState returns the state recorded by the last successful apply of
+// the entity with ID entityId, or nil if there is none.
+func loadState(entityId string) (*appliedState, error) {
+	if stateDir() == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(stateFilePath(entityId))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read state of %s: %w", entityId, err)
+	}
+	var state appliedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("cannot parse state file %s: %w", stateFilePath(entityId), err)
+	}
+	return &state, nil
+}
+
+// writeJSONAtomic writes v as JSON to the file at filePath, creating
+// its directory if necessary. The file is replaced atomically, so that a
+// crash never leaves a half-written file behind.
+func writeJSONAtomic(filePath string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("cannot create directory for %s: %w", filePath, err)
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(filePath), "."+filepath.Base(filePath)+".")
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", filePath, err)
+	}
+	defer os.Remove(tempFile.Name()) // fails harmlessly after the rename
+	_, err = tempFile.Write(append(data, '\n'))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", filePath, err)
+	}
+	if err := os.Rename(tempFile.Name(), filePath); err != nil {
+		return fmt.Errorf("cannot write %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// saveState records the state of the entity with ID entityId after a
+// successful apply.
+func saveState(entityId string, state appliedState) error {
+	if stateDir() == "" {
+		return nil
+	}
+	return withStateLock(func() error {
+		return writeJSONAtomic(stateFilePath(entityId), state)
+	})
+}
+
+// recordApplied saves the current state of the freshly applied entity e.
+func recordApplied(e Entity) error {
+	commit, err := currentBackend.Resolve(e.Path, "HEAD")
+	if err != nil {
+		return err
+	}
+	return saveState(e.ID, appliedState{
+		Commit:    commit,
+		URL:       e.URL,
+		Path:      e.Path,
+		Revision:  e.Revision,
+		AppliedAt: time.Now().UTC(),
+	})
+}
+
+// localChanges describes how the repository of entity e was changed
+// since the last apply, or returns emptystring if it is still exactly as
+// the last apply left it: the origin still points to the url we cloned
+// from (or already to the url we are about to apply, which is not the
+// user's doing), HEAD is still at the commit we checked out and the
+// worktree is clean. If there is no record of a last apply, we cannot tell, so that
+// counts as a change, too.
+func localChanges(e Entity) (string, error) {
+	state, err := loadState(e.ID)
+	if err != nil {
+		return "", err
+	}
+	if state == nil || state.Path != e.Path {
+		return "was not provisioned by holo-git-repos", nil
+	}
+
+	origin, err := originURL(e.Path)
+	if err != nil || (origin != state.URL && origin != e.URL) {
+		return "has a different origin than " + state.URL, nil
+	}
+
+	head, err := currentBackend.Resolve(e.Path, "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if head != state.Commit {
+		return "is not at the last applied commit " + state.Commit, nil
+	}
+
+	clean, err := isClean(e.Path)
+	if err != nil {
+		return "", err
+	}
+	if !clean {
+		return "has uncommitted changes or untracked files", nil
+	}
+	return "", nil
+}
+
+// isUpToDate checks whether the repository of entity e already is what
+// applying it would produce: origin points to the url, HEAD is at the
+// commit the revision currently points to on the remote, and the
+// worktree is clean. Only the remote is asked, the repository is not
+// changed. If the revision cannot be resolved this way, we cannot tell,
+// so it does not count as up to date. The remote is not asked again if
+// that fails, since applying retries anyway.
+func isUpToDate(e Entity) (bool, error) {
+	policy, err := e.networkPolicy()
+	if err != nil {
+		return false, err
+	}
+	policy.retries = 0
+
+	origin, err := originURL(e.Path)
+	if err != nil || origin != e.URL {
+		return false, nil
+	}
+
+	commit, ok, err := remoteCommit(e.Path, e.URL, e.Revision, policy)
+	if err != nil || !ok {
+		// e.g. the remote is unreachable, then the actual apply reports that
+		return false, nil
+	}
+	head, err := currentBackend.Resolve(e.Path, "HEAD")
+	if err != nil {
+		return false, err
+	}
+	if head != commit {
+		return false, nil
+	}
+	return isClean(e.Path)
+}
+
+// recordAppliedIfChanged records the state of entity e unless the
+// recorded state already matches it. This is used when an apply had
+// nothing to do, so that the time of the last apply is kept.
+func recordAppliedIfChanged(e Entity) error {
+	state, err := loadState(e.ID)
+	if err != nil {
+		return err
+	}
+	head, err := currentBackend.Resolve(e.Path, "HEAD")
+	if err != nil {
+		return err
+	}
+	if state != nil && state.Commit == head && state.URL == e.URL &&
+		state.Path == e.Path && state.Revision == e.Revision {
+		return nil
+	}
+	return recordApplied(e)
+}
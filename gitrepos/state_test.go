@@ -0,0 +1,189 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// setTemporaryStateDir sets HOLO_STATE_DIR to a new temporary directory.
+// The caller should defer os.Unsetenv("HOLO_STATE_DIR").
+func setTemporaryStateDir(t *testing.T) string {
+	dir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary state directory")
+	os.Setenv("HOLO_STATE_DIR", dir)
+	return dir
+}
+
+func TestStateRoundtrip(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+
+	state, err := loadState("entity")
+	assertErrNil(t, err, "Cannot load missing state")
+	if state != nil {
+		t.Fatalf("Expected no state, got %v", state)
+	}
+
+	saved := appliedState{"0123abcd", "url", "/path", "main", time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)}
+	assertErrNil(t, saveState("entity", saved), "Cannot save state")
+	state, err = loadState("entity")
+	assertErrNil(t, err, "Cannot load state")
+	assertEq(t, *state, saved)
+}
+
+func TestStateWithoutStateDir(t *testing.T) {
+	os.Unsetenv("HOLO_STATE_DIR")
+	assertErrNil(t, saveState("entity", appliedState{Commit: "0123abcd"}), "Cannot save state")
+	state, err := loadState("entity")
+	assertErrNil(t, err, "Cannot load state")
+	if state != nil {
+		t.Fatalf("Expected no state, got %v", state)
+	}
+}
+
+func TestApplyRecordsState(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	state, err := loadState(entityId)
+	assertErrNil(t, err, "Cannot load state")
+	assertEq(t, state.Commit, gitHead(t, source))
+	assertEq(t, state.URL, source)
+	assertEq(t, state.Path, target)
+	assertEq(t, state.Revision, "main")
+}
+
+// Apply: Target is exactly what the last apply left there and not forced
+// => fetch, checkout
+func TestApplyUnchangedNoForce(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	newCommit := makeTemporaryCommit(t, source)
+
+	assertErrNil(t, Apply(entityId, Options{}), "Second apply failed")
+	assertEq(t, gitHead(t, target), newCommit)
+}
+
+// Apply: url is mistyped, then fixed, and not forced
+// => the failed apply leaves origin alone, so that the fixed one goes ahead
+func TestApplyFailedUrlNoForce(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	newCommit := makeTemporaryCommit(t, source)
+
+	entityFile := path.Join(os.Getenv("HOLO_RESOURCE_DIR"), entityId)
+	setUrl := func(url string) {
+		content := "url=" + url + "\npath=" + target + "\nrevision=main\n"
+		assertErrNil(t, ioutil.WriteFile(entityFile, []byte(content), 0644), "Cannot write entity file")
+	}
+	setUrl(source + "-mistyped")
+	assertEq(t, Apply(entityId, Options{}) != nil, true)
+	origin, err := originURL(target)
+	assertErrNil(t, err, "Cannot get origin")
+	assertEq(t, origin, source)
+
+	setUrl(source)
+	assertErrNil(t, Apply(entityId, Options{}), "Apply with fixed url failed")
+	assertEq(t, gitHead(t, target), newCommit)
+}
+
+// an origin that already points to the entity's url is no change of the
+// user's
+func TestLocalChangesOrigin(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	e, err := ParseEntity(entityId, Options{})
+	assertErrNil(t, err, "Cannot parse entity")
+
+	// the entity moved to a new url, and origin points there already
+	e.URL = source + "-moved"
+	assertErrNil(t, setOrigin(target, e.URL), "Cannot set origin")
+	reason, err := localChanges(e)
+	assertErrNil(t, err, "Cannot check for local changes")
+	assertEq(t, reason, "")
+
+	// origin points somewhere else
+	assertErrNil(t, setOrigin(target, source+"-elsewhere"), "Cannot set origin")
+	reason, err = localChanges(e)
+	assertErrNil(t, err, "Cannot check for local changes")
+	assertEq(t, reason, "has a different origin than "+source)
+}
+
+// Apply: Target was changed by the user and not forced
+// => "needs force"
+func TestApplyChangedNoForce(t *testing.T) {
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+
+	// untracked file
+	untracked := makeTemporaryEntityFile(t, target, "", "", "")
+	err := Apply(entityId, Options{})
+	conflict, ok := err.(*TargetConflictError)
+	if !ok {
+		t.Fatalf("Expected *TargetConflictError, got %v", err)
+	}
+	assertEq(t, conflict.Reason, "has uncommitted changes or untracked files")
+	assertErrNil(t, os.Remove(untracked), "Cannot remove untracked file")
+
+	// local commit
+	makeTemporaryCommit(t, target)
+	err = Apply(entityId, Options{})
+	if _, ok := err.(*TargetConflictError); !ok {
+		t.Fatalf("Expected *TargetConflictError, got %v", err)
+	}
+}
+
+// Apply: Target already is in the desired state
+// => "not changed", state is recorded if it was missing
+func TestApplyNotChanged(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+
+	setTemporaryStateDir(t)
+	defer os.Unsetenv("HOLO_STATE_DIR")
+	for _, force := range []bool{false, true} {
+		err := Apply(entityId, Options{Force: force})
+		assertEq(t, err, ErrNotChanged)
+	}
+	state, err := loadState(entityId)
+	assertErrNil(t, err, "Cannot load state")
+	assertEq(t, state.Commit, gitHead(t, target))
+}
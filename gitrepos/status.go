@@ -0,0 +1,131 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"errors"
+	"os"
+)
+
+// States of a repository as reported by Status.
+const (
+	StateMissing         = "missing"
+	StateNotRepo         = "not-a-repository"
+	StateDifferentRemote = "different-remote"
+	StateDirty           = "dirty"
+	StateDiverged        = "diverged"
+	StateAhead           = "ahead"
+	StateBehind          = "behind"
+	StateUpToDate        = "up-to-date"
+	StateUnknown         = "unknown" // the revision is not available locally
+	StateError           = "error"
+)
+
+// RepoStatus describes the state of the repository of an entity. It is
+// also what 'holo-git-repos status --json' prints.
+type RepoStatus struct {
+	Entity   string `json:"entity"`
+	Path     string `json:"path"`
+	URL      string `json:"url"`
+	Revision string `json:"revision"`
+	State    string `json:"state"`
+	Origin   string `json:"origin,omitempty"`
+	Head     string `json:"head,omitempty"`
+	Desired  string `json:"desired,omitempty"` // commit the revision points to
+	Ahead    int    `json:"ahead"`
+	Behind   int    `json:"behind"`
+	Dirty    bool   `json:"dirty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// getStatus inspects the repository of entity e. The remote is not
+// contacted, so "behind" refers to what was fetched last.
+func getStatus(e Entity) RepoStatus {
+	s := RepoStatus{Entity: e.ID, Path: e.Path, URL: e.URL, Revision: e.Revision}
+	fail := func(err error) RepoStatus {
+		s.State, s.Error = StateError, err.Error()
+		return s
+	}
+
+	if _, err := os.Stat(e.Path); os.IsNotExist(err) {
+		s.State = StateMissing
+		return s
+	} else if err != nil {
+		return fail(err)
+	}
+	isRepo, err := isGitRepo(e.Path)
+	if err != nil {
+		return fail(err)
+	}
+	if !isRepo {
+		s.State = StateNotRepo
+		return s
+	}
+
+	s.Origin, _ = originURL(e.Path)
+	if s.Head, err = currentBackend.Resolve(e.Path, "HEAD"); err != nil {
+		return fail(err)
+	}
+	clean, err := isClean(e.Path)
+	if err != nil {
+		return fail(err)
+	}
+	s.Dirty = !clean
+	if s.Desired, err = resolveLocal(e.Path, e.Revision); err == nil {
+		if s.Ahead, s.Behind, err = currentBackend.AheadBehind(e.Path, s.Desired); err != nil {
+			return fail(err)
+		}
+	}
+
+	switch {
+	case s.Origin != e.URL:
+		s.State = StateDifferentRemote
+	case s.Dirty:
+		s.State = StateDirty
+	case s.Desired == "":
+		s.State = StateUnknown
+	case s.Ahead > 0 && s.Behind > 0:
+		s.State = StateDiverged
+	case s.Ahead > 0:
+		s.State = StateAhead
+	case s.Behind > 0:
+		s.State = StateBehind
+	default:
+		s.State = StateUpToDate
+	}
+	return s
+}
+
+// Status reports the state of the repository of every entity in the
+// resource directory. The remote is not contacted. If some entity files
+// are broken, the statuses of the others are returned along with a
+// *BrokenEntitiesError.
+func Status(opts Options) ([]RepoStatus, error) {
+	entities, err := ParseEntities(opts)
+	var broken *BrokenEntitiesError
+	if err != nil && !errors.As(err, &broken) {
+		return nil, err
+	}
+	statuses := make([]RepoStatus, len(entities))
+	for i, e := range entities {
+		statuses[i] = getStatus(e)
+	}
+	return statuses, err
+}
@@ -0,0 +1,84 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestGetStatus(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryApplyEnv(t, source, "main")
+	e, err := ParseEntity(entityId, Options{})
+	assertErrNil(t, err, "Cannot parse entity")
+
+	assertEq(t, getStatus(e).State, StateMissing)
+
+	assertErrNil(t, os.Mkdir(target, 0755), "Cannot create target")
+	assertEq(t, getStatus(e).State, StateNotRepo)
+	assertErrNil(t, os.Remove(target), "Cannot remove target")
+
+	assertErrNil(t, Apply(entityId, Options{}), "Apply failed")
+	assertEq(t, getStatus(e).State, StateUpToDate)
+
+	makeTemporaryCommit(t, target)
+	s := getStatus(e)
+	assertEq(t, s.State, StateAhead)
+	assertEq(t, s.Ahead, 1)
+
+	makeTemporaryCommit(t, source)
+	assertErrNil(t, runGitInDir(false, target, "fetch", "--quiet"), "Cannot fetch")
+	s = getStatus(e)
+	assertEq(t, s.State, StateDiverged)
+	assertEq(t, s.Behind, 1)
+
+	makeTemporaryEntityFile(t, target, "", "", "")
+	assertEq(t, getStatus(e).State, StateDirty)
+
+	assertErrNil(t, runGitInDir(false, target, "remote", "set-url", "origin", "elsewhere"), "Cannot set url")
+	assertEq(t, getStatus(e).State, StateDifferentRemote)
+}
+
+func TestStatus(t *testing.T) {
+	entityId, target := makeTemporaryApplyEnv(t, "TestStatus_testUrl", "main")
+
+	statuses, err := Status(Options{})
+	assertErrNil(t, err, "Status failed")
+	assertEq(t, len(statuses), 1)
+	assertEq(t, statuses[0].Entity, entityId)
+	assertEq(t, statuses[0].Path, target)
+	assertEq(t, statuses[0].State, StateMissing)
+}
+
+func TestStatusBrokenEntity(t *testing.T) {
+	_, _ = makeTemporaryApplyEnv(t, "TestStatusBrokenEntity_testUrl", "main")
+	err := ioutil.WriteFile(path.Join(os.Getenv("HOLO_RESOURCE_DIR"), "broken"), []byte("broken\n"), 0644)
+	assertErrNil(t, err, "Cannot write broken entity file")
+
+	// the valid entity is still reported
+	statuses, err := Status(Options{})
+	assertEq(t, len(statuses), 1)
+	if _, ok := err.(*BrokenEntitiesError); !ok {
+		t.Fatalf("Expected *BrokenEntitiesError, got %v", err)
+	}
+}
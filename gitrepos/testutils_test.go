@@ -0,0 +1,93 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package gitrepos
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/laerling/holo-git-repos/internal/testutils"
+)
+
+// makeTemporaryEntityFile creates a valid temporary entity file. It
+// returns the whole path of the file (without expanded symlinks,
+// though).
+func makeTemporaryEntityFile(t *testing.T, baseDir string, url string, targetDir string, revision string) string {
+
+	// create temporary entity file
+	tempFile, err := ioutil.TempFile(baseDir, "")
+	assertErrNil(t, err, "Cannot open temporary file")
+
+	// write contents
+	_, err = tempFile.WriteString("url=" + url + "\npath=" + targetDir + "\nrevision=" + revision)
+	assertErrNil(t, err, "Cannot write to temporary file")
+
+	// get file name
+	tempFileInfo, err := tempFile.Stat()
+	assertErrNil(t, err, "Cannot stat temporary file")
+	tempFilePath := path.Join(baseDir, tempFileInfo.Name())
+	return tempFilePath
+}
+
+// makeTemporaryCommit adds a new file to the git repository at repo,
+// commits it and returns the ID of the new commit.
+func makeTemporaryCommit(t *testing.T, repo string) string {
+	_ = makeTemporaryEntityFile(t, repo, "", "", "")
+	assertErrNil(t, runGitInDir(false, repo, "add", "-A"), "Cannot add files to git repo")
+	err := runGitInDir(false, repo, "-c", "user.name=test", "-c", "user.email=test@example.com",
+		"commit", "-q", "-m", "temporary commit")
+	assertErrNil(t, err, "Cannot commit to git repo")
+	return gitHead(t, repo)
+}
+
+// gitHead returns the commit ID of HEAD in the git repository at repo.
+func gitHead(t *testing.T, repo string) string {
+	out, err := exec.Command("git", "-C", repo, "rev-parse", "HEAD").Output()
+	assertErrNil(t, err, "Cannot get HEAD of "+repo)
+	return strings.TrimSpace(string(out))
+}
+
+// makeTemporaryApplyEnv creates a resource directory with an entity
+// that clones url with the given revision into a not yet existing
+// temporary target path. HOLO_RESOURCE_DIR is set accordingly.
+// It returns the entity ID and the target path.
+func makeTemporaryApplyEnv(t *testing.T, url string, revision string) (string, string) {
+	targetDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary directory for cloning into")
+	target := path.Join(targetDir, "repo")
+
+	resourceDir, err := ioutil.TempDir(os.TempDir(), "")
+	assertErrNil(t, err, "Cannot create temporary resource directory")
+	entityId := path.Base(makeTemporaryEntityFile(t, resourceDir, url, target, revision))
+	os.Setenv("HOLO_RESOURCE_DIR", resourceDir)
+	return entityId, target
+}
+
+// the helpers shared with the tests of the command (see testutils)
+var (
+	assertErrNil         = testutils.AssertErrNil
+	assertEq             = testutils.AssertEq
+	getFunctionOutput    = testutils.GetFunctionOutput
+	makeTemporaryGitRepo = testutils.MakeTemporaryGitRepo
+)
@@ -0,0 +1,96 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+// Package testutils provides the helpers shared by the tests of
+// holo-git-repos and of its gitrepos package.
+package testutils
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+)
+
+// AssertErrNil fails with msg if err is not nil.
+func AssertErrNil(t *testing.T, err error, msg string) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(msg + ": " + err.Error())
+	}
+}
+
+// AssertEq fails if value and expected are not equal.
+func AssertEq(t *testing.T, value interface{}, expected interface{}) {
+	t.Helper()
+	if value != expected {
+		t.Fatalf("\nExpected: %v\nFound %v\n", expected, value)
+	}
+}
+
+// GetFunctionOutput calls a function and returns whatever that
+// function printed to stdout as a string. If you want to pass
+// arguments to that function, let the caller define a lambda
+// expression that calls the function with the desired arguments and
+// pass that expression to GetFunctionOutput.
+// For example:
+//
+//	func greet(person string) {
+//		fmt.Println("Hello, " + person)
+//	}
+//
+//	func TestGreet(t *testing.T) {
+//		f := func() { greet("you!") }
+//		AssertEq(t, GetFunctionOutput(f), "Hello, you!\n")
+//	}
+func GetFunctionOutput(f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		panic("Cannot syscall pipe: " + err.Error())
+	}
+
+	// call function with changed stdout
+	oldStdout := os.Stdout
+	os.Stdout = w
+	f()
+	os.Stdout = oldStdout
+	w.Close()
+
+	// read function output
+	var buf bytes.Buffer
+	io.Copy(&buf, r)
+	return buf.String()
+}
+
+// MakeTemporaryGitRepo creates a git repository with branch main and
+// one commit in a new temporary directory and returns its path.
+func MakeTemporaryGitRepo(t *testing.T) string {
+	t.Helper()
+	repo, err := ioutil.TempDir(os.TempDir(), "")
+	AssertErrNil(t, err, "Cannot create temporary directory for git repo")
+	AssertErrNil(t, ioutil.WriteFile(path.Join(repo, "file"), []byte("content\n"), 0644), "Cannot create file")
+	for _, args := range [][]string{{"init", "-q", "-b", "main"}, {"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "temporary commit"}} {
+		AssertErrNil(t, exec.Command("git", append([]string{"-C", repo}, args...)...).Run(), "Cannot create git repo")
+	}
+	return repo
+}
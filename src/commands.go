@@ -0,0 +1,220 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/laerling/holo-git-repos/gitrepos"
+)
+
+// commands are the commands of holo-git-repos besides the holo
+// operations, by name. Each takes the arguments following its name on
+// the command line and prints its results to stdout.
+var commands = map[string]func(args []string) error{
+	"validate": validate,
+	"status":   status,
+	"plan":     plan,
+	"restore":  restore,
+	"undo":     undo,
+}
+
+// entityIds returns ids or, if it is empty, the IDs of all entities in
+// $HOLO_RESOURCE_DIR that can be parsed. Broken entities are warned
+// about and returned as *gitrepos.BrokenEntitiesError along with the
+// others.
+func entityIds(ids []string) ([]string, error) {
+	if len(ids) > 0 {
+		return ids, nil
+	}
+	entities, err := gitrepos.ParseEntities(gitrepos.Options{})
+	if fatalErr := gitrepos.ReportBrokenEntities(err); fatalErr != nil {
+		return nil, fatalErr
+	}
+	for _, e := range entities {
+		ids = append(ids, e.ID)
+	}
+	return ids, err
+}
+
+// validate executes the validate command. It parses the entities with
+// the given IDs, or all entities in $HOLO_RESOURCE_DIR if no IDs are
+// given, and prints every problem it finds. Unlike the holo operations,
+// it treats warnings as problems, too. It returns an error if any
+// problems were found.
+func validate(ids []string) error {
+	if len(ids) == 0 {
+		var err error
+		if ids, err = gitrepos.EntityIDs(gitrepos.Options{}); err != nil {
+			return err
+		}
+	}
+
+	problems := 0
+	for _, id := range ids {
+		diags, err := gitrepos.Validate(id, gitrepos.Options{})
+		if err != nil {
+			return err
+		}
+		for _, d := range diags {
+			fmt.Println(d)
+			problems++
+		}
+	}
+	if problems > 0 {
+		return fmt.Errorf("found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// statusDetails returns a human readable summary of everything besides
+// the state that is worth knowing about s.
+func statusDetails(s gitrepos.RepoStatus) string {
+	var details []string
+	if s.Origin != "" && s.Origin != s.URL {
+		details = append(details, "origin is "+s.Origin)
+	}
+	if s.Ahead > 0 {
+		details = append(details, strconv.Itoa(s.Ahead)+" ahead")
+	}
+	if s.Behind > 0 {
+		details = append(details, strconv.Itoa(s.Behind)+" behind")
+	}
+	if s.Dirty {
+		details = append(details, "uncommitted changes")
+	}
+	if s.Error != "" {
+		details = append(details, s.Error)
+	}
+	return strings.Join(details, ", ")
+}
+
+// printStatusTable prints the statuses as a table.
+func printStatusTable(w io.Writer, statuses []gitrepos.RepoStatus) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENTITY\tPATH\tSTATE\tDETAILS")
+	for _, s := range statuses {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Entity, s.Path, s.State, statusDetails(s))
+	}
+	return tw.Flush()
+}
+
+// status executes the status command. It reports the state of the
+// repository of every entity in $HOLO_RESOURCE_DIR, as a table or, if
+// args contains "--json", as a JSON array.
+func status(args []string) error {
+	asJSON := false
+	for _, arg := range args {
+		if arg != "--json" {
+			return &usageError{"holo-git-repos status: Unknown argument " + arg}
+		}
+		asJSON = true
+	}
+
+	statuses, err := gitrepos.Status(gitrepos.Options{})
+	if fatalErr := gitrepos.ReportBrokenEntities(err); fatalErr != nil {
+		return fatalErr
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if encErr := encoder.Encode(statuses); encErr != nil {
+			return encErr
+		}
+	} else if printErr := printStatusTable(os.Stdout, statuses); printErr != nil {
+		return printErr
+	}
+	return err
+}
+
+// plan executes the plan command. It prints what applying the entities
+// with the given IDs, or all entities in $HOLO_RESOURCE_DIR if no IDs are
+// given, would do. With "--force" as first argument, it shows what
+// force-applying would do. Nothing is changed.
+func plan(args []string) error {
+	var opts gitrepos.Options
+	if len(args) > 0 && args[0] == "--force" {
+		opts.Force = true
+		args = args[1:]
+	}
+
+	ids, err := entityIds(args)
+	if ids == nil && err != nil {
+		return err
+	}
+	for _, id := range ids {
+		actions, planErr := gitrepos.Plan(id, opts)
+		if planErr != nil {
+			return planErr
+		}
+		fmt.Println("git-repo:" + id)
+		for _, action := range actions {
+			fmt.Println("  " + action)
+		}
+	}
+	return err
+}
+
+// restore executes the restore command. Without arguments, it lists the
+// quarantined targets. Otherwise, it restores the quarantined target
+// with the given ID, or the latest one of the entity with the given ID
+// (see gitrepos.Restore).
+func restore(args []string) error {
+	if len(args) == 0 {
+		entries, err := gitrepos.Quarantined()
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			fmt.Println(entry.ID + "\t" + entry.OriginalPath)
+		}
+		return nil
+	}
+
+	entry, err := gitrepos.Restore(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Println("restored " + entry.OriginalPath)
+	return nil
+}
+
+// undo executes the undo command. It restores the repository of the
+// entity with the ID given in args to the state before the last
+// force-apply (see gitrepos.Undo).
+func undo(args []string) error {
+	entityId, err := requireArg(args, "undo")
+	if err != nil {
+		return err
+	}
+	path, timestamp, err := gitrepos.Undo(entityId, gitrepos.Options{})
+	if err != nil {
+		return err
+	}
+	fmt.Println("restored " + path + " from backup " + timestamp)
+	return nil
+}
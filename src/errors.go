@@ -0,0 +1,103 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/laerling/holo-git-repos/gitrepos"
+)
+
+// Exit codes of holo-git-repos, see exitCode.
+const (
+	exitOK     = 0
+	exitError  = 1
+	exitUsage  = 2
+	exitSignal = 128 // plus the number of the signal we were interrupted by
+
+	// classified failures, see failureExitCodes
+	exitAuth            = 10
+	exitUnreachable     = 11
+	exitNotFound        = 12
+	exitUnknownRevision = 13
+	exitPermission      = 14
+	exitDiskFull        = 15
+	exitCorrupted       = 16
+)
+
+// failureExitCodes are the exit codes of the failure classes (see
+// gitrepos.Classify).
+var failureExitCodes = map[gitrepos.Failure]int{
+	gitrepos.FailureAuth:            exitAuth,
+	gitrepos.FailureUnreachable:     exitUnreachable,
+	gitrepos.FailureNotFound:        exitNotFound,
+	gitrepos.FailureUnknownRevision: exitUnknownRevision,
+	gitrepos.FailurePermission:      exitPermission,
+	gitrepos.FailureDiskFull:        exitDiskFull,
+	gitrepos.FailureCorrupted:       exitCorrupted,
+}
+
+// usageError is returned when the program is called with wrong arguments
+// or settings.
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// exitCode returns the exit code holo-git-repos exits with for err.
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var usageErr *usageError
+	if errors.As(err, &usageErr) {
+		return exitUsage
+	}
+	if code, ok := failureExitCodes[gitrepos.Classify(err)]; ok {
+		return code
+	}
+	return exitError
+}
+
+// report prints err to stderr, with a hint on what to do if there is
+// one (see gitrepos.FailureHint), logs it and returns the exit code for
+// it. If we were interrupted (see gitrepos.HandleSignals), whatever went
+// wrong was because of that, so the signal is reported instead.
+func report(err error) int {
+	if sig := gitrepos.InterruptedBy(); sig != nil {
+		fmt.Fprintln(os.Stderr, "holo-git-repos: interrupted by", sig)
+		gitrepos.Logf(gitrepos.LogError, "interrupted by %s", sig)
+		return exitSignal + int(sig.(syscall.Signal))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if hint := gitrepos.FailureHint(err); hint != "" {
+			fmt.Fprintln(os.Stderr, "hint:", hint)
+		}
+		gitrepos.Logf(gitrepos.LogError, "%s", err)
+	}
+	return exitCode(err)
+}
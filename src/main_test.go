@@ -0,0 +1,162 @@
+/*******************************************************************************
+*
+* Copyright 2021 laerling <laerling@posteo.de>
+*
+* This program is free software: you can redistribute it and/or modify it under
+* the terms of the GNU General Public License as published by the Free Software
+* Foundation, either version 3 of the License, or (at your option) any later
+* version.
+*
+* This program is distributed in the hope that it will be useful, but WITHOUT
+* ANY WARRANTY; without even the implied warranty of MERCHANTABILITY or FITNESS
+* FOR A PARTICULAR PURPOSE. See the GNU General Public License for more
+* details.
+*
+* You should have received a copy of the GNU General Public License along with
+* this program. If not, see <http://www.gnu.org/licenses/>.
+*
+*******************************************************************************/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/laerling/holo-git-repos/gitrepos"
+	"github.com/laerling/holo-git-repos/internal/testutils"
+)
+
+var (
+	assertErrNil         = testutils.AssertErrNil
+	assertEq             = testutils.AssertEq
+	getFunctionOutput    = testutils.GetFunctionOutput
+	makeTemporaryGitRepo = testutils.MakeTemporaryGitRepo
+)
+
+// makeTemporaryEntity writes an entity file for url and a target path
+// that does not exist yet into a new temporary resource directory, sets
+// $HOLO_RESOURCE_DIR to it and returns the entity ID and the target path.
+func makeTemporaryEntity(t *testing.T, url string) (string, string) {
+	resourceDir := t.TempDir()
+	target := path.Join(t.TempDir(), "repo")
+	err := ioutil.WriteFile(path.Join(resourceDir, "repo"), []byte("url="+url+"\npath="+target+"\n"), 0644)
+	assertErrNil(t, err, "Cannot write entity file")
+	os.Setenv("HOLO_RESOURCE_DIR", resourceDir)
+	return "repo", target
+}
+
+func TestInfo(t *testing.T) {
+	// since we're calling the main function we want to pass
+	// arguments via os.Args, just as if we had executed the
+	// binary.
+	origArgs := os.Args
+	os.Args = []string{"holo", "info"}
+	mainOutput := getFunctionOutput(main)
+	os.Args = origArgs
+
+	// check output
+	assertEq(t, mainOutput, "MIN_API_VERSION=3\nMAX_API_VERSION=3\n")
+}
+
+// TODO: Remove this general test in favor of the specific tests in gitrepos
+func TestApply(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryEntity(t, source)
+	defer os.Unsetenv("HOLO_RESOURCE_DIR")
+
+	// call main function as if binary had been called
+	// Positive case - expecting clone to succeed
+	origArgs := os.Args
+	os.Args = []string{"holo", "apply", entityId}
+	mainOutput := getFunctionOutput(main)
+	os.Args = origArgs
+
+	// check output
+	assertEq(t, mainOutput, "")
+	_, err := os.Stat(path.Join(target, ".git"))
+	assertErrNil(t, err, "Nothing cloned")
+}
+
+func TestRunUsage(t *testing.T) {
+	for _, args := range [][]string{{}, {"apply"}, {"unknown-operation"}, {"scan", "--yaml"},
+		{"status", "--yaml"}, {"undo"}} {
+		assertEq(t, exitCode(run(args)), exitUsage)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected int
+	}{
+		{nil, exitOK},
+		{errors.New("other"), exitError},
+		{&usageError{"wrong"}, exitUsage},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.ENOSPC}, exitDiskFull},
+		{&os.LinkError{Op: "rename", Old: "a", New: "b", Err: syscall.EACCES}, exitPermission},
+		{&gitrepos.GitError{Args: []string{"fetch"}, Err: errors.New("exit status 128"),
+			Stderr: "fatal: repository 'https://example.com/repo.git/' not found"}, exitNotFound},
+	}
+	for _, c := range cases {
+		assertEq(t, exitCode(c.err), c.expected)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	entityId, target := makeTemporaryEntity(t, "TestStatus_testUrl")
+	defer os.Unsetenv("HOLO_RESOURCE_DIR")
+
+	var err error
+	output := getFunctionOutput(func() { err = status(nil) })
+	assertErrNil(t, err, "Status failed")
+	lines := strings.Split(output, "\n")
+	assertEq(t, strings.Fields(lines[0])[2], "STATE")
+	assertEq(t, strings.Join(strings.Fields(lines[1]), " "), entityId+" "+target+" "+gitrepos.StateMissing)
+
+	output = getFunctionOutput(func() { err = status([]string{"--json"}) })
+	assertErrNil(t, err, "Status failed")
+	var statuses []gitrepos.RepoStatus
+	assertErrNil(t, json.Unmarshal([]byte(output), &statuses), "Cannot parse JSON output")
+	assertEq(t, len(statuses), 1)
+	assertEq(t, statuses[0].Entity, entityId)
+	assertEq(t, statuses[0].State, gitrepos.StateMissing)
+
+	details := statusDetails(gitrepos.RepoStatus{URL: "a", Origin: "b", Ahead: 1, Behind: 2, Dirty: true})
+	assertEq(t, details, "origin is b, 1 ahead, 2 behind, uncommitted changes")
+}
+
+func TestValidate(t *testing.T) {
+	_, _ = makeTemporaryEntity(t, "TestValidate_testUrl")
+	defer os.Unsetenv("HOLO_RESOURCE_DIR")
+	resourceDir := os.Getenv("HOLO_RESOURCE_DIR")
+	err := ioutil.WriteFile(path.Join(resourceDir, "invalid"), []byte("url=x\nrevision=main\n"), 0644)
+	assertErrNil(t, err, "Cannot write invalid entity file")
+
+	output := getFunctionOutput(func() { err = validate([]string{"repo"}) })
+	assertErrNil(t, err, "Valid entity did not validate")
+	assertEq(t, output, "")
+
+	output = getFunctionOutput(func() { err = validate(nil) })
+	assertEq(t, err != nil, true)
+	assertEq(t, output, path.Join(resourceDir, "invalid")+": missing key 'path'\n")
+}
+
+func TestPlan(t *testing.T) {
+	source := makeTemporaryGitRepo(t)
+	entityId, target := makeTemporaryEntity(t, source)
+	defer os.Unsetenv("HOLO_RESOURCE_DIR")
+
+	var err error
+	output := getFunctionOutput(func() { err = plan(nil) })
+	assertErrNil(t, err, "Plan failed")
+	lines := strings.Split(output, "\n")
+	assertEq(t, lines[0], "git-repo:"+entityId)
+	assertEq(t, lines[1], "  clone "+source+" into "+target)
+}